@@ -2,102 +2,138 @@ package apod
 
 import (
 	"fmt"
-	"io"
-	"io/ioutil"
-	"net/http"
 	"os"
-	"regexp"
 	"time"
 )
 
 const (
 	apodSite = "http://apod.nasa.gov/"
-	format   = "060102"
+	// format is the date layout used in the APOD page names
+	format = "060102"
+	// isoFormat is the date layout used for user input and storage
+	isoFormat = "2006-01-02"
 )
 
-var imageExpr = regexp.MustCompile(`<a href="(.*\.(jpg|gif|png))"`)
+// FirstDate is the date of the first Astronomy Picture of the Day.
+var FirstDate = ADate{time.Date(1995, 6, 16, 0, 0, 0, 0, time.UTC)}
 
-var youtubeExpr = regexp.MustCompile(`src="//www.youtube.com/embed(.*)"`)
-
-// ADate is an APOD date string
-type ADate string
+// ADate is the calendar date of an APOD.
+type ADate struct {
+	t time.Time
+}
 
+// NewADate returns the calendar date of t, in the location of t.
 func NewADate(t time.Time) ADate {
-	return ADate(t.Format(format))
+	y, m, d := t.Date()
+	return ADate{time.Date(y, m, d, 0, 0, 0, 0, time.UTC)}
+}
+
+// ParseADate parses a date in ISO form (2006-01-02) or as the six digit code
+// used in the APOD page names (060102). Six digit years from 95 on are
+// taken to be in the 1900s, as the archive started in 1995.
+func ParseADate(s string) (ADate, error) {
+	if t, err := time.Parse(isoFormat, s); err == nil {
+		return NewADate(t), nil
+	}
+	t, err := time.Parse(format, s)
+	if err != nil || len(s) != len(format) {
+		return ADate{}, fmt.Errorf("Invalid date %q, expected YYYY-MM-DD", s)
+	}
+	year := 2000 + t.Year()%100
+	if year >= 2095 {
+		year -= 100
+	}
+	return ADate{time.Date(year, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)}, nil
 }
 
-func (d *ADate) String() string {
-	return string(*d)
+// String returns the date in ISO form.
+func (d ADate) String() string {
+	return d.t.Format(isoFormat)
 }
 
-func (d *ADate) Back() *ADate {
-	da := d.Date()
-	if da == nil {
-		return nil
+// Code returns the date as used in the APOD page names.
+func (d ADate) Code() string {
+	return d.t.Format(format)
+}
+
+// Back returns the day before.
+func (d ADate) Back() ADate {
+	return ADate{d.t.AddDate(0, 0, -1)}
+}
+
+// Date returns the date as a time at midnight UTC.
+func (d ADate) Date() time.Time {
+	return d.t
+}
+
+// IsZero reports whether d is the zero date.
+func (d ADate) IsZero() bool {
+	return d.t.IsZero()
+}
+
+// Before reports whether d lies before o.
+func (d ADate) Before(o ADate) bool {
+	return d.t.Before(o.t)
+}
+
+// Validate checks that d lies between the first APOD and today.
+func (d ADate) Validate(today ADate) error {
+	if d.Before(FirstDate) {
+		return fmt.Errorf("%s lies before the first APOD on %s", d, FirstDate)
 	}
-	p := NewADate(da.AddDate(0, 0, -1))
-	return &p
+	if today.Before(d) {
+		return fmt.Errorf("%s lies in the future", d)
+	}
+	return nil
 }
 
-func (d *ADate) Date() *time.Time {
-	t, err := time.Parse(format, d.String())
+// MarshalText implements encoding.TextMarshaler using the ISO form.
+func (d ADate) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, it accepts the forms
+// of ParseADate.
+func (d *ADate) UnmarshalText(b []byte) error {
+	p, err := ParseADate(string(b))
 	if err != nil {
-		return nil
+		return err
 	}
-	return &t
+	*d = p
+	return nil
 }
 
 // APOD encapsulates communicating with apod.nasa.gov
 type APOD struct {
-	Client *http.Client
-	Site   string
+	*Fetcher
+	Site string
 }
 
 // NewAPOD constructs a new APOD object
 func NewAPOD() *APOD {
 	a := APOD{
-		Client: http.DefaultClient,
-		Site:   apodSite,
+		Fetcher: NewFetcher(),
+		Site:    apodSite,
 	}
 	return &a
 }
 
-// ContainsImage parses an APOD page for a linked image, returns image URL if successful (maybe empty)  or an error
-func (a *APOD) ContainsImage(url string) (string, error) {
-	content, err := a.loadPage(url)
+// modTime returns the modification time of path, the zero time if it can not
+// be read.
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
 	if err != nil {
-		return "", err
-	}
-	var m []string
-	m = youtubeExpr.FindStringSubmatch(content)
-	if m != nil {
-		return "", nil
+		return time.Time{}
 	}
-	m = imageExpr.FindStringSubmatch(content)
-	if m != nil && m[1] != "" {
-		return a.Site + "apod/" + m[1], nil
-	}
-	return "", nil
+	return fi.ModTime()
 }
 
-// Download fetches the url argument and stores the result in the path in the file argument
-func (a *APOD) Download(file, url string) error {
-	output, err := os.Create(file)
-	if err != nil {
-		return err
-	}
-	defer output.Close()
-	resp, err := a.Client.Get(url)
-	if err != nil {
-		return err
-	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Getting %s returned status: %s", url, resp.Status)
-	}
-	defer resp.Body.Close()
-
-	_, err = io.Copy(output, resp.Body)
-	return err
+// unchanged reports whether fi describes the same file as before, unchanged.
+// Files written by writeFileAtomic are new files, even within the
+// resolution of the modification time.
+func unchanged(fi, before os.FileInfo) bool {
+	return fi != nil && before != nil && os.SameFile(fi, before) &&
+		fi.ModTime().Equal(before.ModTime()) && fi.Size() == before.Size()
 }
 
 func exists(path string) (bool, error) {
@@ -113,17 +149,5 @@ func exists(path string) (bool, error) {
 
 // UrlForDate returns the URL for the APOD page for the given ISO date.
 func (a *APOD) UrlForDate(isodate ADate) string {
-	return fmt.Sprintf("%sapod/ap%s.html", a.Site, isodate.String())
-}
-
-func (a *APOD) loadPage(url string) (string, error) {
-	resp, err := a.Client.Get(url)
-	if err != nil {
-		return "", err
-	}
-	b, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	return string(b), nil
+	return fmt.Sprintf("%sapod/ap%s.html", a.Site, isodate.Code())
 }
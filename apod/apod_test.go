@@ -1,53 +1,119 @@
 package apod
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"os"
 	"testing"
+	"time"
 )
 
 const testAPODSite = "http://localhost:8765/"
 
+// TestMain serves the test data on testAPODSite.
+func TestMain(m *testing.M) {
+	l, err := net.Listen("tcp", "localhost:8765")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	go http.Serve(l, http.FileServer(http.Dir("../testdata/apod.nasa.gov/")))
+	os.Exit(m.Run())
+}
+
 func testAPOD() *APOD {
 	a := NewAPOD()
 	a.Site = testAPODSite
 	return a
 }
 
+// adate parses s, it panics on malformed dates
+func adate(s string) ADate {
+	d, err := ParseADate(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// apodOn returns the APOD wallpaper of the date s
+func apodOn(s string) Wallpaper {
+	return Wallpaper{Source: apodName, Date: adate(s)}
+}
+
 func TestADateString(t *testing.T) {
-	a := ADate("140121")
-	assert.Equal(t, "140121", a.String())
+	a := adate("140121")
+	assert.Equal(t, "2014-01-21", a.String())
+	assert.Equal(t, "140121", a.Code())
 }
 
 func TestADateDate(t *testing.T) {
-	a := ADate("140121")
-	assert.Equal(t, &testDate, a.Date())
+	a := adate("140121")
+	assert.Equal(t, testDate, a.Date())
 }
 
 func TestNewADate(t *testing.T) {
 	a := NewADate(testDate)
-	assert.Equal(t, &testDate, a.Date())
-	assert.Equal(t, "140121", a.String())
+	assert.Equal(t, testDate, a.Date())
+	assert.Equal(t, "140121", a.Code())
+}
+
+func TestNewADateDropsClock(t *testing.T) {
+	loc := time.FixedZone("UTC+10", 10*60*60)
+	a := NewADate(time.Date(2014, 1, 21, 23, 59, 0, 0, loc))
+	assert.Equal(t, testDate, a.Date())
 }
 
 func TestADateBack(t *testing.T) {
-	a := ADate("140101")
+	a := adate("140101")
 	b := a.Back()
-	assert.Equal(t, "131231", b.String())
+	assert.Equal(t, "131231", b.Code())
 }
 
-func TestContainsImageWithServer(t *testing.T) {
-	go func() {
-		http.ListenAndServe(":8765", http.FileServer(http.Dir("../testdata/apod.nasa.gov/")))
-	}()
-	testHome := setupTestHome(t)
-	defer cleanUp(t, testHome)
-	a := testAPOD()
-	url, err := a.ContainsImage(a.UrlForDate(testDateSeptember))
+func TestParseADate(t *testing.T) {
+	for in, expected := range map[string]string{
+		"950616":     "1995-06-16",
+		"991231":     "1999-12-31",
+		"000101":     "2000-01-01",
+		"140921":     "2014-09-21",
+		"700101":     "2070-01-01",
+		"1995-06-16": "1995-06-16",
+		"2014-09-21": "2014-09-21",
+	} {
+		d, err := ParseADate(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, expected, d.String(), in)
+	}
+}
+
+func TestParseADateMalformed(t *testing.T) {
+	for _, in := range []string{"", "14092", "1409211", "141321", "2014-13-01", "21-09-2014"} {
+		_, err := ParseADate(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestADateValidate(t *testing.T) {
+	today := adate("2014-09-21")
+	assert.NoError(t, adate("1995-06-16").Validate(today))
+	assert.NoError(t, today.Validate(today))
+	assert.Equal(t, "1995-06-15 lies before the first APOD on 1995-06-16", adate("1995-06-15").Validate(today).Error())
+	assert.Equal(t, "2014-09-22 lies in the future", adate("2014-09-22").Validate(today).Error())
+}
+
+func TestADateJSON(t *testing.T) {
+	var s State
+	assert.NoError(t, json.Unmarshal([]byte(`{"DateCode":"950616","Options":"fit"}`), &s))
+	assert.Equal(t, adate("1995-06-16"), s.DateCode)
+	bs, err := json.Marshal(s)
 	assert.NoError(t, err)
-	assert.Equal(t, a.Site+"apod/image/1409/m8_chua_2500.jpg", url)
+	assert.Equal(t, `{"DateCode":"1995-06-16","Source":"","Options":"fit"}`, string(bs))
 }
 
 func TestCollectTestData(t *testing.T) {
@@ -64,32 +130,13 @@ func TestCollectTestData(t *testing.T) {
 
 func TestUrlForDate(t *testing.T) {
 	apod := NewAPOD()
-	url := apod.UrlForDate(testDateString)
+	url := apod.UrlForDate(adate(testDateString))
 	assert.Equal(t, "http://apod.nasa.gov/apod/ap140121.html", url)
-}
-
-func TestContainsImageYoutubeDay(t *testing.T) {
-	apod := testAPOD()
-	url, err := apod.ContainsImage(testAPODSite + "apod/ap141013.html")
-	assert.NoError(t, err)
-	assert.Equal(t, "", url)
-}
-
-func TestContainsImage(t *testing.T) {
-	apod := testAPOD()
-	url, err := apod.ContainsImage(testAPODSite + "apod/ap140921.html")
-	assert.NoError(t, err)
-	assert.Equal(t, "http://localhost:8765/apod/image/1409/saturnequinox_cassini_7227.jpg", url)
-}
-
-func TestLoadPage(t *testing.T) {
-	a := testAPOD()
-	page, err := a.loadPage(testAPODSite + "apod/ap140921.html")
-	assert.NoError(t, err)
-	assert.Equal(t, 5069, len(page))
+	url = apod.UrlForDate(adate("1995-06-16"))
+	assert.Equal(t, "http://apod.nasa.gov/apod/ap950616.html", url)
 }
 
 func TestDownloadNoGoodStatus(t *testing.T) {
 	a := testAPOD()
-	assert.Equal(t, "Getting http://localhost:8765/NotFound returned status: 404 Not Found", a.Download("unused", "http://localhost:8765/NotFound").Error())
+	assert.Equal(t, "Getting http://localhost:8765/NotFound returned status: 404 Not Found", a.Download(context.Background(), "unused", "http://localhost:8765/NotFound").Error())
 }
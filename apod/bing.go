@@ -0,0 +1,135 @@
+package apod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	bingName = "bing"
+	bingSite = "https://www.bing.com/"
+	// bingArchive is the number of days the Bing image archive goes back
+	bingArchive = 8
+	bingFormat  = "20060102"
+	// bingKeep is how long the archive is kept, so that the days of a fetch
+	// share a single request
+	bingKeep = time.Minute
+)
+
+// Bing is the source for the daily image on the Bing homepage.
+type Bing struct {
+	*Fetcher
+	Site   string
+	Market string
+	// Clock tells which days are still in the archive.
+	Clock Clock
+	// mu guards the archive last fetched, from archiveURL at fetched
+	mu         sync.Mutex
+	archive    *bingArchiveResponse
+	archiveURL string
+	fetched    time.Time
+}
+
+// NewBing constructs a Bing source for the en-US market.
+func NewBing(f *Fetcher) *Bing {
+	return &Bing{Fetcher: f, Site: bingSite, Market: "en-US", Clock: SystemClock}
+}
+
+type bingArchiveResponse struct {
+	Images []struct {
+		StartDate     string
+		URL           string
+		Title         string
+		Copyright     string
+		CopyrightLink string
+	}
+}
+
+// Name returns bing.
+func (b *Bing) Name() string {
+	return bingName
+}
+
+// Dates lists the days from to to that are still in the Bing archive.
+func (b *Bing) Dates(from, to ADate) []ADate {
+	today := NewADate(b.Clock.Now())
+	if today.Before(to) {
+		to = today
+	}
+	oldest := NewADate(today.Date().AddDate(0, 0, 1-bingArchive))
+	var dates []ADate
+	for d := to; !d.Before(from) && !d.Before(oldest); d = d.Back() {
+		dates = append(dates, d)
+	}
+	return dates
+}
+
+// fetchArchive returns the Bing archive, fetching it only if it was not
+// fetched in the last bingKeep. The concurrent lookups of a fetch wait for
+// the one request.
+func (b *Bing) fetchArchive(ctx context.Context) (*bingArchiveResponse, error) {
+	archive := fmt.Sprintf("%sHPImageArchive.aspx?format=js&idx=0&n=%d&mkt=%s", b.Site, bingArchive, url.QueryEscape(b.Market))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.archive != nil && b.archiveURL == archive && time.Since(b.fetched) < bingKeep {
+		return b.archive, nil
+	}
+	resp, err := b.get(ctx, archive)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	r := new(bingArchiveResponse)
+	err = json.NewDecoder(resp.Body).Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode %s, because: %v", archive, err)
+	}
+	b.archive, b.archiveURL, b.fetched = r, archive, time.Now()
+	return r, nil
+}
+
+// Entry looks up the image of the date in the Bing archive.
+func (b *Bing) Entry(ctx context.Context, date ADate) (*Entry, error) {
+	r, err := b.fetchArchive(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e := &Entry{Source: bingName, Date: date, URL: b.PageURL(date)}
+	for _, img := range r.Images {
+		if img.StartDate != date.Date().Format(bingFormat) {
+			continue
+		}
+		base, err := url.Parse(b.Site)
+		if err != nil {
+			return nil, err
+		}
+		ref, err := url.Parse(img.URL)
+		if err != nil {
+			return nil, err
+		}
+		e.Title = img.Title
+		e.Credit = img.Copyright
+		if i := strings.LastIndex(img.Copyright, "("); i >= 0 {
+			if e.Title == "" {
+				e.Title = strings.TrimSpace(img.Copyright[:i])
+			}
+			e.Credit = strings.Trim(img.Copyright[i:], "()")
+		}
+		e.Copyright = strings.Contains(img.Copyright, "©")
+		e.HiRes = base.ResolveReference(ref).String()
+		if img.CopyrightLink != "" {
+			e.URL = img.CopyrightLink
+		}
+	}
+	return e, nil
+}
+
+// PageURL returns the Bing homepage, as Bing has no page per day.
+func (b *Bing) PageURL(date ADate) string {
+	return b.Site
+}
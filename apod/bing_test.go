@@ -0,0 +1,100 @@
+package apod
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const bingArchiveJSON = `{"images":[
+{"startdate":"%s","url":"/th?id=OHR.Lagoon_EN-US123_1920x1080.jpg&rf=LaDigue_1920x1080.jpg","title":"A cosmic lagoon","copyright":"The Lagoon Nebula (© Remus Chua)","copyrightlink":"https://www.bing.com/search?q=lagoon+nebula"},
+{"startdate":"%s","url":"/th?id=OHR.Saturn_EN-US456_1920x1080.jpg","title":"","copyright":"Saturn at equinox (NASA/JPL)","copyrightlink":""}
+]}`
+
+func testBing(t *testing.T) (*Bing, ADate, *httptest.Server) {
+	today := NewADate(time.Now())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/HPImageArchive.aspx", r.URL.Path)
+		assert.Equal(t, "en-US", r.URL.Query().Get("mkt"))
+		fmt.Fprintf(w, bingArchiveJSON, today.Date().Format(bingFormat), today.Back().Date().Format(bingFormat))
+	}))
+	b := NewBing(NewFetcher())
+	b.Site = server.URL + "/"
+	return b, today, server
+}
+
+func TestBingEntry(t *testing.T) {
+	b, today, server := testBing(t)
+	defer server.Close()
+	e, err := b.Entry(context.Background(), today)
+	assert.NoError(t, err)
+	assert.Equal(t, Wallpaper{Source: bingName, Date: today}, e.Wallpaper())
+	assert.Equal(t, "A cosmic lagoon", e.Title)
+	assert.Equal(t, "© Remus Chua", e.Credit)
+	assert.True(t, e.Copyright)
+	assert.Equal(t, server.URL+"/th?id=OHR.Lagoon_EN-US123_1920x1080.jpg&rf=LaDigue_1920x1080.jpg", e.HiRes)
+	assert.Equal(t, "https://www.bing.com/search?q=lagoon+nebula", e.URL)
+}
+
+func TestBingEntryWithoutTitle(t *testing.T) {
+	b, today, server := testBing(t)
+	defer server.Close()
+	e, err := b.Entry(context.Background(), today.Back())
+	assert.NoError(t, err)
+	assert.Equal(t, "Saturn at equinox", e.Title)
+	assert.Equal(t, "NASA/JPL", e.Credit)
+	assert.False(t, e.Copyright)
+	assert.Equal(t, b.Site, e.URL)
+}
+
+func TestBingEntryAbsent(t *testing.T) {
+	b, today, server := testBing(t)
+	defer server.Close()
+	e, err := b.Entry(context.Background(), today.Back().Back())
+	assert.NoError(t, err)
+	assert.Equal(t, "", e.HiRes)
+}
+
+func TestBingDates(t *testing.T) {
+	b := NewBing(NewFetcher())
+	today := NewADate(time.Now())
+	dates := b.Dates(adate("140101"), NewADate(today.Date().AddDate(0, 0, 3)))
+	assert.Equal(t, bingArchive, len(dates))
+	assert.Equal(t, today, dates[0])
+	assert.Equal(t, 0, len(b.Dates(adate("140101"), adate("140201"))))
+}
+
+func TestBingDatesClock(t *testing.T) {
+	b := NewBing(NewFetcher())
+	b.Clock = ClockAt(adate("140110"))
+	dates := b.Dates(adate("140101"), adate("140201"))
+	assert.Equal(t, bingArchive, len(dates))
+	assert.Equal(t, adate("140110"), dates[0])
+	assert.Equal(t, adate("140103"), dates[len(dates)-1])
+}
+
+func TestBingFetchesArchiveOnce(t *testing.T) {
+	today := NewADate(time.Now())
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprintf(w, bingArchiveJSON, today.Date().Format(bingFormat), today.Back().Date().Format(bingFormat))
+	}))
+	defer server.Close()
+	b := NewBing(NewFetcher())
+	b.Site = server.URL + "/"
+	for _, d := range b.Dates(today.Back().Back(), today) {
+		_, err := b.Entry(context.Background(), d)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, requests, "the days share the archive")
+	b.fetched = b.fetched.Add(-bingKeep)
+	_, err := b.Entry(context.Background(), today)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requests, "an old archive is fetched again")
+}
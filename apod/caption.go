@@ -0,0 +1,184 @@
+package apod
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"io/ioutil"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The corners a caption can be put in.
+const (
+	captionTopLeft     = "top-left"
+	captionTopRight    = "top-right"
+	captionBottomLeft  = "bottom-left"
+	captionBottomRight = "bottom-right"
+)
+
+const (
+	defaultCaptionSize       = 18
+	defaultCaptionBoxOpacity = 0.5
+)
+
+// caption configures the title, date and credit drawn on the renditions.
+type caption struct {
+	// Font is the path of a TrueType or OpenType font, Go Regular if empty.
+	Font string
+	// Size is the size of the text in pixels, 18 if zero.
+	Size float64
+	// Position is top-left, top-right, bottom-left or bottom-right, bottom-right if empty.
+	Position string
+	// Opacity of the text, from 0 to 1, 1 if not set.
+	Opacity *float64
+	// BoxOpacity of the black box behind the text, 0.5 if not set.
+	BoxOpacity *float64
+}
+
+// opacity returns the opacity of the text.
+func (c caption) opacity() float64 {
+	if c.Opacity == nil {
+		return 1
+	}
+	return *c.Opacity
+}
+
+// boxOpacity returns the opacity of the box behind the text.
+func (c caption) boxOpacity() float64 {
+	if c.BoxOpacity == nil {
+		return defaultCaptionBoxOpacity
+	}
+	return *c.BoxOpacity
+}
+
+// overlay is a caption with the text to draw.
+type overlay struct {
+	caption
+	lines []string
+}
+
+// overlay returns the caption for w, or nil if no caption is configured. The
+// text comes from the metadata kept with the image. An image downloaded
+// without it has it fetched and kept now, failing that only the date is shown.
+func (f *Frontend) overlay(w Wallpaper) *overlay {
+	if f.Config.Caption == nil {
+		return nil
+	}
+	e, err := f.storage.Entry(w)
+	if err != nil {
+		if src, err := source(f.loader.Sources, w.Source); err == nil {
+			f.loader.completeEntry(context.Background(), src, w)
+			e, _ = f.storage.Entry(w)
+		}
+	}
+	return &overlay{caption: *f.Config.Caption, lines: captionLines(w, e)}
+}
+
+// captionLines returns the title, and the date with the credit.
+func captionLines(w Wallpaper, e *Entry) []string {
+	var lines []string
+	if e != nil && e.Title != "" {
+		title := e.Title
+		if e.VideoStill {
+			title += " (video)"
+		}
+		lines = append(lines, title)
+	}
+	second := w.Date.String()
+	if w.Source != apodName {
+		second = w.String()
+	}
+	if e != nil && e.Credit != "" {
+		credit := e.Credit
+		if e.Copyright {
+			credit = "© " + credit
+		}
+		second += ", " + credit
+	}
+	return append(lines, second)
+}
+
+// key identifies the caption and its text in the file name of a rendition.
+func (o *overlay) key() string {
+	h := fnv.New32a()
+	fmt.Fprintf(h, "%q %v %q %v %v %q", o.Font, o.Size, o.Position, o.opacity(), o.boxOpacity(), o.lines)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+func (o *overlay) face() (font.Face, error) {
+	data := goregular.TTF
+	if o.Font != "" {
+		var err error
+		data, err = ioutil.ReadFile(o.Font)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the font %s, because: %v", o.Font, err)
+	}
+	size := o.Size
+	if size <= 0 {
+		size = defaultCaptionSize
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// draw draws the lines on a translucent box in the corner of img.
+func (o *overlay) draw(img *image.RGBA) error {
+	face, err := o.face()
+	if err != nil {
+		return err
+	}
+	defer face.Close()
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+	pad := lineHeight / 2
+	width := 0
+	for _, line := range o.lines {
+		if w := font.MeasureString(face, line).Ceil(); w > width {
+			width = w
+		}
+	}
+	box := image.Rect(0, 0, width+2*pad, len(o.lines)*lineHeight+2*pad)
+	b := img.Bounds()
+	margin := lineHeight
+	switch o.Position {
+	case captionTopLeft:
+		box = box.Add(b.Min.Add(image.Pt(margin, margin)))
+	case captionTopRight:
+		box = box.Add(image.Pt(b.Max.X-margin-box.Dx(), b.Min.Y+margin))
+	case captionBottomLeft:
+		box = box.Add(image.Pt(b.Min.X+margin, b.Max.Y-margin-box.Dy()))
+	case "", captionBottomRight:
+		box = box.Add(b.Max.Sub(image.Pt(margin, margin)).Sub(box.Size()))
+	default:
+		return fmt.Errorf("Unknown caption position: %s", o.Position)
+	}
+	draw.Draw(img, box, image.NewUniform(color.NRGBA{0, 0, 0, alpha(o.boxOpacity())}), image.Point{}, draw.Over)
+	d := font.Drawer{Dst: img, Src: image.NewUniform(color.NRGBA{0xff, 0xff, 0xff, alpha(o.opacity())}), Face: face}
+	for i, line := range o.lines {
+		d.Dot = fixed.P(box.Min.X+pad, box.Min.Y+pad+i*lineHeight+metrics.Ascent.Ceil())
+		d.DrawString(line)
+	}
+	return nil
+}
+
+// alpha converts an opacity from 0 to 1 to an alpha value.
+func alpha(opacity float64) uint8 {
+	if opacity < 0 {
+		opacity = 0
+	}
+	if opacity > 1 {
+		opacity = 1
+	}
+	return uint8(opacity*0xff + 0.5)
+}
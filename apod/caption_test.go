@@ -0,0 +1,96 @@
+package apod
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptionLines(t *testing.T) {
+	e := &Entry{Title: "The Lagoon Nebula in Stars Dust and Gas", Credit: "Remus Chua", Copyright: true}
+	assert.Equal(t, []string{"The Lagoon Nebula in Stars Dust and Gas", "2014-09-21, © Remus Chua"}, captionLines(apodOn("140921"), e))
+	assert.Equal(t, []string{"bing:2014-09-21"}, captionLines(Wallpaper{Source: "bing", Date: adate("140921")}, nil))
+}
+
+func grayImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	return img
+}
+
+func TestOverlayDraw(t *testing.T) {
+	gray := color.RGBA{0x80, 0x80, 0x80, 0x80}
+	// the corner lies in the margin, 40 pixels into the image lies in the box
+	for position, c := range map[string][2]image.Point{
+		"":                 {{399, 199}, {359, 159}},
+		captionBottomRight: {{399, 199}, {359, 159}},
+		captionTopLeft:     {{0, 0}, {40, 40}},
+		captionTopRight:    {{399, 0}, {359, 40}},
+		captionBottomLeft:  {{0, 199}, {40, 159}},
+	} {
+		corner, inside := c[0], c[1]
+		img := grayImage(400, 200)
+		o := &overlay{caption: caption{Position: position}, lines: []string{"M8", "2014-09-21"}}
+		assert.NoError(t, o.draw(img))
+		assert.Equal(t, gray, img.RGBAAt(corner.X, corner.Y), "the margin is left alone: %s", position)
+		assert.NotEqual(t, gray, img.RGBAAt(inside.X, inside.Y), "the box is drawn: %s", position)
+		assert.Equal(t, gray, img.RGBAAt(399-corner.X, 199-corner.Y), position)
+	}
+	o := &overlay{caption: caption{Position: "middle"}, lines: []string{"M8"}}
+	assert.Equal(t, "Unknown caption position: middle", o.draw(grayImage(40, 20)).Error())
+	o = &overlay{caption: caption{Font: "/nonexistent.ttf"}, lines: []string{"M8"}}
+	assert.Error(t, o.draw(grayImage(40, 20)))
+}
+
+func TestOverlayZeroOpacity(t *testing.T) {
+	var c config
+	assert.NoError(t, json.Unmarshal([]byte(`{"Caption":{"Opacity":0,"BoxOpacity":0}}`), &c))
+	o := &overlay{caption: *c.Caption, lines: []string{"M8"}}
+	img := grayImage(400, 200)
+	assert.NoError(t, o.draw(img))
+	assert.Equal(t, grayImage(400, 200), img, "nothing is drawn at opacity 0")
+	assert.NotEqual(t, (&overlay{lines: []string{"M8"}}).key(), o.key())
+}
+
+func TestOverlayKey(t *testing.T) {
+	o := &overlay{lines: []string{"M8"}}
+	key := o.key()
+	assert.Len(t, key, 8)
+	assert.Equal(t, key, (&overlay{lines: []string{"M8"}}).key())
+	assert.NotEqual(t, key, (&overlay{lines: []string{"M9"}}).key())
+	assert.NotEqual(t, key, (&overlay{caption: caption{Size: 30}, lines: []string{"M8"}}).key())
+}
+
+func TestScreenFileCaption(t *testing.T) {
+	f, _, testHome := frontendForTestMonitors(t, monitorsSame)
+	defer cleanUp(t, testHome)
+	w := apodOn("140921")
+	writeTestImage(t, f.Config.fileName(w), detailedImage(image.Rect(0, 0, 120, 40), image.Rect(0, 0, 120, 40)))
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: w.Date, Title: "M8"}))
+	f.Config.Caption = &caption{Size: 12}
+	file := f.screenFile(w, image.Pt(320, 180))
+	assert.Equal(t, renditionDir(), filepath.Dir(file))
+	name := filepath.Base(file)
+	assert.True(t, strings.HasPrefix(name, "apod-img-2014-09-21-blur-320x180-"), name)
+	assert.Equal(t, "-"+f.overlay(w).key()+".jpg", strings.TrimPrefix(name, "apod-img-2014-09-21-blur-320x180"))
+}
+
+func TestOverlayWithoutMetadata(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, f.Config, "140921", "140119")
+	f.Config.Caption = &caption{}
+	w := apodOn("140921")
+	assert.Equal(t, []string{"Saturn at Equinox", "2014-09-21, Cassini Imaging Team, ISS, JPL, ESA, NASA"}, f.overlay(w).lines)
+	present, err := exists(f.Config.metaFileName(w))
+	assert.NoError(t, err)
+	assert.True(t, present, "the metadata fetched is kept")
+	assert.Equal(t, []string{"2014-01-19"}, f.overlay(apodOn("140119")).lines, "the date is shown if the page can not be had")
+}
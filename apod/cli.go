@@ -0,0 +1,615 @@
+package apod
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/haklop/gnotifier"
+)
+
+const (
+	defaultParallel = 4
+	defaultDelay    = 500 * time.Millisecond
+	defaultInterval = 30 * time.Minute
+)
+
+// command is a subcommand of apod-bg.
+type command struct {
+	name string
+	// args names the positional arguments
+	args []string
+	// optArgs names the positional arguments that may follow args, or be left out
+	optArgs []string
+	help    string
+	// serve marks the commands the server handles, they are forwarded to it while it runs
+	serve bool
+	// noConfig marks the commands that run without loading the configuration
+	noConfig bool
+	// flags defines the flags of the command on fs and returns its action
+	flags func(f *Frontend, fs *flag.FlagSet) func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{name: "config", args: []string{"SETTER"}, noConfig: true,
+		help: "initializes apod-bg for the chosen wallpaper setter: " + strings.Join(setterNames(), ", ") +
+			", or the aliases barewm, gnome and lxde, or auto to detect it",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, args []string) error {
+				err := f.Configure(ctx, args[0])
+				if err != nil {
+					return fmt.Errorf("Could not properly configure the apod-bg, because: %v\n", err)
+				}
+				f.Log.Printf("apod-bg was successfully configured\n")
+				return nil
+			}
+		}},
+	{name: "unconfig", help: "removes the autostart entry for LXDE",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error {
+				return f.removeAutostart()
+			}
+		}},
+	{name: "fetch", args: []string{"N"}, serve: true, help: "downloads the images of the last N days",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			o := fetchFlags(fs)
+			return func(ctx context.Context, args []string) error {
+				report, err := f.fetch(ctx, *f.loader, o, args[0])
+				if report == nil {
+					return err
+				}
+				f.Log.Printf("Fetch report: %v\n", report)
+				if err != nil {
+					return fmt.Errorf("Error during fetch: %v", err)
+				}
+				return nil
+			}
+		}},
+	{name: "next", optArgs: []string{"OUTPUT"}, serve: true, help: "shows the next wallpaper, on all monitors or on OUTPUT",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, args []string) error { return f.jumpCommand(optArg(args, 0), 1) }
+		}},
+	{name: "prev", optArgs: []string{"OUTPUT"}, serve: true, help: "shows the previous wallpaper, on all monitors or on OUTPUT",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, args []string) error { return f.jumpCommand(optArg(args, 0), -1) }
+		}},
+	{name: "jump", args: []string{"N"}, optArgs: []string{"OUTPUT"}, serve: true,
+		help: "jumps N wallpapers further, use negative numbers to jump backward, on all monitors or on OUTPUT",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, args []string) error {
+				n, err := strconv.Atoi(args[0])
+				if err != nil {
+					return fmt.Errorf("Invalid jump: %s", args[0])
+				}
+				return f.jumpCommand(optArg(args, 1), n)
+			}
+		}},
+	{name: "back", serve: true, help: "shows the wallpaper shown before, like the back button of a browser",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error { return f.browseCommand("back", f.Back) }
+		}},
+	{name: "forward", serve: true, help: "shows the wallpaper shown after the current one, after going back",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error { return f.browseCommand("forward", f.Forward) }
+		}},
+	{name: "history", serve: true, help: "lists the wallpapers shown, when and with their titles",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error {
+				list, err := f.History()
+				if err != nil {
+					return err
+				}
+				f.Log.Printf("History:\n%s", list)
+				return nil
+			}
+		}},
+	{name: "random", serve: true, help: "shows a random archived wallpaper",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error { return withLock(f.RandomArchive) }
+		}},
+	{name: "fav", optArgs: []string{"OUTPUT"}, serve: true, help: "marks the current wallpaper, or the one on OUTPUT, as a favorite, shown more often at random",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, args []string) error { return f.markCommand(optArg(args, 0), markFavorite) }
+		}},
+	{name: "unfav", optArgs: []string{"OUTPUT"}, serve: true, help: "takes the favorite or banned mark away from the current wallpaper, or the one on OUTPUT",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, args []string) error { return f.markCommand(optArg(args, 0), "") }
+		}},
+	{name: "ban", optArgs: []string{"OUTPUT"}, serve: true, help: "bans the current wallpaper, or the one on OUTPUT, from jump and random and shows the next",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, args []string) error { return f.markCommand(optArg(args, 0), markBanned) }
+		}},
+	{name: "favorites", serve: true, help: "lists the favorite wallpapers with their titles",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error {
+				list, err := f.Favorites()
+				if err != nil {
+					return err
+				}
+				f.Log.Printf("Favorites:\n%s", list)
+				return nil
+			}
+		}},
+	{name: "search", args: []string{"QUERY"}, help: "lists the downloaded wallpapers whose title, keywords or explanation match QUERY, the best first",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			show := fs.Bool("show", false, "shows the best match")
+			rotate := fs.Bool("rotate", false, "restricts jump, random and the daemon to the matches, an empty QUERY lifts the restriction")
+			max := fs.Int("n", 10, "number of matches to list")
+			return func(ctx context.Context, args []string) error {
+				if *rotate {
+					err := withLock(func() error { return f.SetRotation(ctx, args[0]) })
+					if err != nil {
+						return fmt.Errorf("Could not restrict the rotation, because: %v", err)
+					}
+					if args[0] == "" {
+						f.Log.Printf("The rotation shows all wallpapers again\n")
+						return nil
+					}
+					f.Log.Printf("The rotation is restricted to the matches of %q\n", args[0])
+				}
+				found, err := f.Search(ctx, args[0])
+				if err != nil {
+					return err
+				}
+				if *show {
+					if err := withLock(func() error { return f.show(found[0]) }); err != nil {
+						return err
+					}
+				}
+				if *max > 0 && len(found) > *max {
+					found = found[:*max]
+				}
+				f.Log.Printf("Found:\n%s", f.describe(found))
+				return nil
+			}
+		}},
+	{name: "rules", help: "checks the rules of the configuration and tells how many wallpapers pass them",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			dryRun := fs.Bool("dry-run", false, "lists every wallpaper with whether it passes the rules, and why not")
+			return func(ctx context.Context, _ []string) error {
+				list, err := f.DryRun()
+				if err != nil {
+					return err
+				}
+				if !*dryRun {
+					lines := strings.Split(strings.TrimSuffix(list, "\n"), "\n")
+					list = lines[len(lines)-1] + "\n"
+				}
+				f.Log.Printf("%s", list)
+				return nil
+			}
+		}},
+	{name: "show", args: []string{"[SOURCE:]DATE"}, help: "shows the image of the date, downloading it if needed",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, args []string) error {
+				w, err := ParseWallpaper(args[0])
+				if err != nil {
+					return err
+				}
+				return f.Show(ctx, w)
+			}
+		}},
+	{name: "info", optArgs: []string{"OUTPUT"}, serve: true, help: "opens the page on the current wallpaper, or the one on OUTPUT, in the default browser",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, args []string) error {
+				err := f.OpenAPODOnBackground(optArg(args, 0))
+				if err != nil {
+					return fmt.Errorf("Could not open the APOD page on background now showing, because: %v\n", err)
+				}
+				f.Log.Printf("Opened the default browser on the APOD-page related to the current background image\n")
+				f.Notify("Browser opened on NASA apod-page belonging to this background")
+				return nil
+			}
+		}},
+	{name: "apod", help: "opens the default browser on the Astronomy Picture of The Day",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error {
+				err := f.OpenAPODToday()
+				if err != nil {
+					return fmt.Errorf("Could not open the APOD page, because: %v\n", err)
+				}
+				mesg := "Opened the default browser on APOD\n"
+				f.Notify(mesg)
+				f.Log.Printf(mesg)
+				return nil
+			}
+		}},
+	{name: "status", serve: true, help: "shows the current wallpaper and its options",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error {
+				status, err := f.Status()
+				if err != nil {
+					return err
+				}
+				f.Log.Printf("Showing %s\n", status)
+				return nil
+			}
+		}},
+	{name: "mode", serve: true, help: "toggles the background sizing options: fit or zoom",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error {
+				var m string
+				err := withLock(func() (err error) {
+					m, err = f.ToggleViewMode()
+					return err
+				})
+				if err != nil {
+					return fmt.Errorf("Could not toggle viewing options: %v\n", err)
+				}
+				f.Log.Printf("Inversed the viewing option to: %s\n", m)
+				return nil
+			}
+		}},
+	{name: "login", help: "does the procedure for a graphical login: downloads todays image and displays it",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			timeout := fs.Duration("timeout", defaultLoginTimeout, "limit for downloading todays image")
+			return func(ctx context.Context, _ []string) error {
+				f.Options.LoginTimeout = *timeout
+				return f.RunAtLogin(ctx)
+			}
+		}},
+	{name: "daemon", help: "keeps running: checks for new images daily and rotates the wallpaper",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			interval := fs.Duration("interval", defaultInterval, "time between two rotations, 0 disables rotating")
+			rotation := fs.String("rotation", rotateRandom, "how to rotate: random or sequential")
+			return func(ctx context.Context, _ []string) error {
+				return NewDaemon(f, *interval, *rotation).Run(ctx)
+			}
+		}},
+	{name: "server", help: "keeps running and serves the other invocations over a socket in $XDG_RUNTIME_DIR",
+		flags: func(f *Frontend, fs *flag.FlagSet) func(context.Context, []string) error {
+			return func(ctx context.Context, _ []string) error {
+				server := NewServer(f)
+				err := server.Listen(socketPath())
+				if err != nil {
+					return err
+				}
+				go func() {
+					<-ctx.Done()
+					f.Log.Printf("Server stopped\n")
+					server.Close()
+				}()
+				f.Log.Printf("Serving on %s\n", socketPath())
+				return server.Serve()
+			}
+		}},
+}
+
+// globalFlags are the flags given before the command, with the deprecated
+// flags that legacyArgs translates to commands.
+type globalFlags struct {
+	logFile  *string
+	nonotify *bool
+	noseed   *bool
+	date     *string
+
+	info     *bool
+	login    *bool
+	days     *int
+	jump     *int
+	config   *string
+	unconfig *bool
+	apod     *bool
+	mode     *bool
+	random   *bool
+	parallel *int
+	delay    *time.Duration
+	daemon   *bool
+	interval *time.Duration
+	rotation *string
+	server   *bool
+	status   *bool
+}
+
+// newGlobalFlags defines the global flags on a flag set of their own, so
+// that the package leaves the flags of the program it is part of alone.
+func newGlobalFlags() (*flag.FlagSet, *globalFlags) {
+	fs := flag.NewFlagSet("apod-bg", flag.ContinueOnError)
+	g := &globalFlags{
+		logFile:  fs.String("log", "", "logfile specification"),
+		nonotify: fs.Bool("nonotify", false, "do not send notifications to the desktop"),
+		noseed:   fs.Bool("noseed", false, "do not seed after configuring"),
+		date:     fs.String("date", "", "specify a date (YYYY-MM-DD) to be considered as now (for testing)"),
+
+		info:     fs.Bool("info", false, "deprecated, use: apod-bg info"),
+		login:    fs.Bool("login", false, "deprecated, use: apod-bg login"),
+		days:     fs.Int("fetch", 0, "deprecated, use: apod-bg fetch N"),
+		jump:     fs.Int("jump", 0, "deprecated, use: apod-bg jump N"),
+		config:   fs.String("config", "", "deprecated, use: apod-bg config SETTER"),
+		unconfig: fs.Bool("unconfig", false, "deprecated, use: apod-bg unconfig"),
+		apod:     fs.Bool("apod", false, "deprecated, use: apod-bg apod"),
+		mode:     fs.Bool("mode", false, "deprecated, use: apod-bg mode"),
+		random:   fs.Bool("random", false, "deprecated, use: apod-bg random"),
+		parallel: fs.Int("parallel", defaultParallel, "deprecated, use: apod-bg fetch -parallel N"),
+		delay:    fs.Duration("delay", defaultDelay, "deprecated, use: apod-bg fetch -delay duration"),
+		daemon:   fs.Bool("daemon", false, "deprecated, use: apod-bg daemon"),
+		interval: fs.Duration("interval", defaultInterval, "deprecated, use: apod-bg daemon -interval duration"),
+		rotation: fs.String("rotation", rotateRandom, "deprecated, use: apod-bg daemon -rotation random|sequential"),
+		server:   fs.Bool("server", false, "deprecated, use: apod-bg server"),
+		status:   fs.Bool("status", false, "deprecated, use: apod-bg status"),
+	}
+	return fs, g
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+// usage prints the global flags and the commands.
+func usage() {
+	fs, _ := newGlobalFlags()
+	out := fs.Output()
+	fmt.Fprintf(out, "Usage: apod-bg [flags] COMMAND [command flags] [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-10s %s\n", c.synopsis(), c.help)
+	}
+	fmt.Fprintf(out, "\nRun apod-bg COMMAND -h for the flags of a command.\n\nFlags:\n")
+	fs.VisitAll(func(fl *flag.Flag) {
+		if !strings.HasPrefix(fl.Usage, "deprecated") {
+			fmt.Fprintf(out, "  -%s\n    \t%s\n", fl.Name, fl.Usage)
+		}
+	})
+}
+
+// Command runs the command named by the first of args, until ctx is done.
+func (f *Frontend) Command(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] == "help" {
+		usage()
+		if len(args) == 0 {
+			return fmt.Errorf("No command given")
+		}
+		return nil
+	}
+	c, ok := findCommand(args[0])
+	if !ok {
+		usage()
+		return fmt.Errorf("Unknown command: %s", args[0])
+	}
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: apod-bg %s\n%s\n", strings.Replace(c.synopsis(), c.name, c.name+" [flags]", 1), c.help)
+		fs.PrintDefaults()
+	}
+	action := c.flags(f, fs)
+	rest := args[1:]
+	hasFlags := false
+	fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+	// commands without flags take their arguments as given, like jump -1
+	if hasFlags || (len(rest) > 0 && (rest[0] == "-h" || rest[0] == "-help")) {
+		if err := fs.Parse(rest); err != nil {
+			return err
+		}
+		rest = fs.Args()
+	}
+	if len(rest) < len(c.args) || len(rest) > len(c.args)+len(c.optArgs) {
+		fs.Usage()
+		if len(c.optArgs) > 0 {
+			return fmt.Errorf("The %s command takes %d to %d argument(s), got %d", c.name, len(c.args), len(c.args)+len(c.optArgs), len(rest))
+		}
+		return fmt.Errorf("The %s command takes %d argument(s), got %d", c.name, len(c.args), len(rest))
+	}
+	// a server runs on the system clock
+	if c.serve && f.Options.Clock == SystemClock {
+		request := c.request(fs, rest)
+		reply, forwarded, err := forward(socketPath(), request)
+		if forwarded {
+			if err != nil {
+				f.Notify(err.Error())
+				return fmt.Errorf("The server could not %s: %v", request, err)
+			}
+			f.Log.Printf("The server did %s: %s\n", request, reply)
+			return nil
+		}
+	}
+	if !c.noConfig {
+		if err := f.Loadconfig(); err != nil {
+			return fmt.Errorf("Could not load the configuration, because: %v\n", err)
+		}
+	}
+	return action(ctx, rest)
+}
+
+// fetchOptions are the flags of the fetch command.
+type fetchOptions struct {
+	workers        *int
+	delay, timeout *time.Duration
+}
+
+// fetchFlags defines the flags of the fetch command on fs.
+func fetchFlags(fs *flag.FlagSet) fetchOptions {
+	return fetchOptions{
+		workers: fs.Int("parallel", defaultParallel, "number of concurrent downloads"),
+		delay:   fs.Duration("delay", defaultDelay, "minimum delay between two requests to the same host"),
+		timeout: fs.Duration("timeout", 0, "limit for the whole fetch, 0 for none"),
+	}
+}
+
+// fetch downloads the images of the last days, as many as the argument says,
+// with l, a copy of the loader of f.
+func (f *Frontend) fetch(ctx context.Context, l Loader, o fetchOptions, days string) (*Report, error) {
+	n, err := strconv.Atoi(days)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("Invalid number of days: %s", days)
+	}
+	l.Workers = *o.workers
+	ctx = withDelay(ctx, *o.delay)
+	if *o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *o.timeout)
+		defer cancel()
+	}
+	return l.LoadPeriod(ctx, f.Today(), n)
+}
+
+// request returns the request that forwards the command to the server, with
+// the flags that were set before its arguments.
+func (c command) request(fs *flag.FlagSet, args []string) string {
+	words := []string{c.name}
+	fs.Visit(func(fl *flag.Flag) {
+		words = append(words, "-"+fl.Name+"="+fl.Value.String())
+	})
+	return strings.Join(append(words, args...), " ")
+}
+
+// synopsis returns the name of c with its arguments, optional ones in brackets.
+func (c command) synopsis() string {
+	words := append([]string{c.name}, c.args...)
+	for _, a := range c.optArgs {
+		words = append(words, "["+a+"]")
+	}
+	return strings.Join(words, " ")
+}
+
+// optArg returns the i-th argument, or "" if it was left out.
+func optArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// jumpCommand jumps n wallpapers, on all outputs if output is empty, and
+// notifies about failure.
+func (f *Frontend) jumpCommand(output string, n int) error {
+	err := withLock(func() error { return f.JumpOutput(output, n) })
+	if err != nil {
+		f.Notify(err.Error())
+		return fmt.Errorf("Could not jump(%d): %v\n", n, err)
+	}
+	f.Log.Printf("Jump was successfull\n")
+	return nil
+}
+
+// browseCommand goes back or forward in the history.
+func (f *Frontend) browseCommand(name string, browse func() error) error {
+	err := withLock(browse)
+	if err != nil {
+		f.Notify(err.Error())
+		return fmt.Errorf("Could not go %s: %v\n", name, err)
+	}
+	f.Log.Printf("Went %s in the history\n", name)
+	return nil
+}
+
+// markCommand marks the wallpaper shown, on all outputs if output is empty, and
+// notifies about it.
+func (f *Frontend) markCommand(output, mark string) error {
+	var w Wallpaper
+	err := withLock(func() (err error) {
+		w, err = f.Mark(output, mark)
+		return err
+	})
+	if err != nil {
+		f.Notify(err.Error())
+		return fmt.Errorf("Could not mark the wallpaper, because: %v\n", err)
+	}
+	mesg := fmt.Sprintf("%s is %s now\n", w, markName(mark))
+	f.Notify(mesg)
+	f.Log.Printf(mesg)
+	return nil
+}
+
+// markName describes the mark for the messages.
+func markName(mark string) string {
+	if mark == "" {
+		return "unmarked"
+	}
+	return mark
+}
+
+// legacyCommands translates the deprecated flags to the arguments of the
+// commands to run in turn. Like the flags did, they run in a fixed order:
+// unconfig, random, info, login, fetch, jump and mode, and config, apod,
+// info, jump and mode end the run.
+func legacyCommands(g *globalFlags) [][]string {
+	var (
+		found [][]string
+		done  bool
+	)
+	add := func(set, last bool, args ...string) {
+		if set && !done {
+			found = append(found, args)
+			done = last
+		}
+	}
+	add(*g.config != "", true, "config", *g.config)
+	add(*g.apod, true, "apod")
+	add(*g.unconfig, false, "unconfig")
+	add(*g.random, false, "random")
+	add(*g.info, true, "info")
+	add(*g.status, true, "status")
+	add(*g.login, false, "login")
+	add(*g.days > 0, false, "fetch", fmt.Sprintf("-parallel=%d", *g.parallel), fmt.Sprintf("-delay=%s", *g.delay), strconv.Itoa(*g.days))
+	add(*g.jump != 0, true, "jump", strconv.Itoa(*g.jump))
+	add(*g.mode, true, "mode")
+	add(*g.daemon, true, "daemon", fmt.Sprintf("-interval=%s", *g.interval), "-rotation="+*g.rotation)
+	add(*g.server, true, "server")
+	return found
+}
+
+// Execute is the entry point for the apod-bg command, args are its
+// arguments without the program name.
+func Execute(args []string) error {
+	fs, g := newGlobalFlags()
+	fs.Usage = usage
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	logger, f, err := initLogging(*g.logFile)
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+	defer f.Close()
+	var front *Frontend
+	logger.Printf("apod-bg starts")
+	opts := Options{Clock: SystemClock, NoSeed: *g.noseed}
+	if *g.date != "" {
+		err := validateDate(*g.date, opts.Clock)
+		if err != nil {
+			logger.Printf("%v\n", err)
+			return err
+		}
+		d, _ := ParseADate(*g.date)
+		opts.Clock = ClockAt(d)
+	}
+	if *g.nonotify {
+		front = NewFrontend(logger, Notifier{gnotifier.NullNotification}, opts)
+	} else {
+		front = NewFrontend(logger, Notifier{gnotifier.Notification}, opts)
+	}
+	runs := [][]string{fs.Args()}
+	if len(fs.Args()) == 0 {
+		if legacy := legacyCommands(g); len(legacy) > 0 {
+			runs = legacy
+			var uses []string
+			for _, args := range legacy {
+				uses = append(uses, "apod-bg "+strings.Join(args, " "))
+			}
+			logger.Printf("Flags as commands are deprecated, use: %s\n", strings.Join(uses, "; "))
+		}
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	for _, args := range runs {
+		if err = front.Command(ctx, args); err != nil {
+			logger.Printf("%v\n", err)
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package apod
+
+import (
+	"context"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func commandForTest(t *testing.T) (*Frontend, string) {
+	f, testHome := frontendForTestConfigured(t)
+	makeTestWallpapers(t, f.Config, "140119", "140120", "140121")
+	makeStateFile(t, "140120", fit)
+	return f, testHome
+}
+
+func assertShowing(t *testing.T, f *Frontend, expected string) {
+	status, err := f.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, expected, status)
+}
+
+func TestCommandJump(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.Command(context.Background(), []string{"jump", "-1"}))
+	assertShowing(t, f, "apod:2014-01-19 fit")
+	assert.NoError(t, f.Command(context.Background(), []string{"next"}))
+	assertShowing(t, f, "apod:2014-01-20 fit")
+	assert.NoError(t, f.Command(context.Background(), []string{"mode"}))
+	assertShowing(t, f, "apod:2014-01-20 zoom")
+	assert.NoError(t, f.Command(context.Background(), []string{"prev"}))
+	assertShowing(t, f, "apod:2014-01-19 zoom")
+	assert.Equal(t, "Could not jump(-1): Begin reached\n", f.Command(context.Background(), []string{"prev"}).Error())
+	assert.Equal(t, "Invalid jump: one", f.Command(context.Background(), []string{"jump", "one"}).Error())
+}
+
+func TestCommandShow(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.Command(context.Background(), []string{"show", "2014-01-21"}))
+	assertShowing(t, f, "apod:2014-01-21 fit")
+	assert.NoError(t, f.Command(context.Background(), []string{"show", "apod:140119"}))
+	assertShowing(t, f, "apod:2014-01-19 fit")
+	assert.Contains(t, f.Command(context.Background(), []string{"show", "21-01-2014"}).Error(), "Invalid date")
+}
+
+func TestCommandArguments(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	assert.Equal(t, "The jump command takes 1 to 2 argument(s), got 0", f.Command(context.Background(), []string{"jump"}).Error())
+	assert.Equal(t, "The status command takes 0 argument(s), got 1", f.Command(context.Background(), []string{"status", "now"}).Error())
+	assert.Equal(t, "Invalid number of days: ten", f.Command(context.Background(), []string{"fetch", "-parallel", "2", "ten"}).Error())
+	assert.Contains(t, f.Command(context.Background(), []string{"fetch", "-workers", "2", "10"}).Error(), "flag provided but not defined: -workers")
+	assert.Equal(t, "Unknown command: shuffle", f.Command(context.Background(), []string{"shuffle"}).Error())
+	assert.Equal(t, "No command given", f.Command(context.Background(), nil).Error())
+	assert.NoError(t, f.Command(context.Background(), []string{"help"}))
+}
+
+func TestCommandForwardsToServer(t *testing.T) {
+	s, _, testHome := serverForTest(t)
+	defer cleanUp(t, testHome)
+	served := make(chan error)
+	go func() { served <- s.Serve() }()
+	client := NewFrontend(nullLogger{}, s.Notifier, Options{})
+	assert.NoError(t, client.Command(context.Background(), []string{"next"}))
+	assertShowing(t, s.Frontend, "apod:2014-01-22 fit")
+	err := client.Command(context.Background(), []string{"fetch", "-parallel", "2", "-timeout", "1m", "ten"})
+	assert.Equal(t, "The server could not fetch -parallel=2 -timeout=1m0s ten: Invalid number of days: ten", err.Error())
+	assert.NoError(t, s.Close())
+	assert.NoError(t, <-served)
+}
+
+func TestLegacyCommands(t *testing.T) {
+	fs, g := newGlobalFlags()
+	assert.Nil(t, legacyCommands(g))
+
+	assert.NoError(t, fs.Parse([]string{"-fetch=5", "-parallel=1", "-delay=0"}))
+	assert.Equal(t, [][]string{{"fetch", "-parallel=1", "-delay=0s", "5"}}, legacyCommands(g))
+
+	assert.NoError(t, fs.Parse([]string{"-login", "-unconfig", "-random"}))
+	assert.Equal(t, [][]string{{"unconfig"}, {"random"}, {"login"}, {"fetch", "-parallel=1", "-delay=0s", "5"}}, legacyCommands(g),
+		"the flags run in the order they always did")
+
+	assert.NoError(t, fs.Parse([]string{"-mode", "-info"}))
+	assert.Equal(t, [][]string{{"unconfig"}, {"random"}, {"info"}}, legacyCommands(g), "info ends the run")
+}
+
+func TestFetchKeepsDelay(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	f.APOD.Delay = time.Millisecond
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	o := fetchFlags(fs)
+	assert.NoError(t, fs.Parse([]string{"-delay=0", "-parallel=1"}))
+	_, err := f.fetch(context.Background(), *f.loader, o, "1")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Millisecond, f.APOD.Delay, "the delay of one fetch does not stick")
+}
@@ -0,0 +1,55 @@
+package apod
+
+import "time"
+
+const defaultLoginTimeout = 2 * time.Minute
+
+// Clock tells the time to the frontend, the loader and the daemon.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the clock of the machine.
+var SystemClock Clock = systemClock{}
+
+// shiftedClock runs along with the system clock, shifted by offset.
+type shiftedClock struct {
+	offset time.Duration
+}
+
+func (c shiftedClock) Now() time.Time {
+	return time.Now().Add(c.offset)
+}
+
+// optionsClock tells the time by the clock of the options, whichever clock
+// is set there now.
+type optionsClock struct {
+	options *Options
+}
+
+func (c optionsClock) Now() time.Time {
+	return c.options.Clock.Now()
+}
+
+// ClockAt returns a clock that runs as if it was set to date today.
+func ClockAt(date ADate) Clock {
+	now := time.Now()
+	today := NewADate(now)
+	return shiftedClock{offset: date.Date().Sub(today.Date())}
+}
+
+// Options configure a Frontend.
+type Options struct {
+	// Clock tells the time, the SystemClock if nil.
+	Clock Clock
+	// NoSeed skips downloading images after configuring.
+	NoSeed bool
+	// LoginTimeout limits the download of RunAtLogin, two minutes if zero.
+	LoginTimeout time.Duration
+}
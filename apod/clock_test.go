@@ -0,0 +1,30 @@
+package apod
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClockAt(t *testing.T) {
+	now := ClockAt(adate(testDateString)).Now()
+	assert.Equal(t, adate(testDateString), NewADate(now))
+	today := ClockAt(NewADate(time.Now())).Now()
+	assert.WithinDuration(t, time.Now(), today, time.Second, "The time of day should run along")
+}
+
+func TestNewFrontendDefaultsToSystemClock(t *testing.T) {
+	f := NewFrontend(nullLogger{}, Notifier{}, Options{})
+	assert.Equal(t, SystemClock, f.Options.Clock)
+	assert.Equal(t, NewADate(time.Now()), f.Today())
+}
+
+func TestLoaderUsesClock(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	setToday(f, "140923")
+	_, err := f.loader.Download(context.Background(), apodOn(testDateSeptember))
+	assert.Equal(t, "2014-09-24 lies in the future", err.Error())
+}
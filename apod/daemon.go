@@ -0,0 +1,137 @@
+package apod
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	rotateRandom     = "random"
+	rotateSequential = "sequential"
+	// publishMargin is waited after midnight, before APOD has its new page
+	publishMargin = 10 * time.Minute
+	// retryCheck is the wait after a failed check for today's image
+	retryCheck = time.Hour
+)
+
+// apodZone is the time zone APOD publishes in.
+var apodZone = loadZone("America/New_York", -5*60*60)
+
+func loadZone(name string, offset int) *time.Location {
+	zone, err := time.LoadLocation(name)
+	if err != nil {
+		return time.FixedZone(name, offset)
+	}
+	return zone
+}
+
+var (
+	errEndReached   = errors.New("End reached")
+	errBeginReached = errors.New("Begin reached")
+)
+
+// Daemon keeps the wallpaper up to date for sessions that last for days.
+type Daemon struct {
+	*Frontend
+	// Interval is the time between two rotations, zero disables rotating.
+	Interval time.Duration
+	// Rotation is random or sequential.
+	Rotation string
+	// Zone is the time zone of the APOD publisher.
+	Zone *time.Location
+}
+
+// NewDaemon constructs a daemon for f.
+func NewDaemon(f *Frontend, interval time.Duration, rotation string) *Daemon {
+	return &Daemon{Frontend: f, Interval: interval, Rotation: rotation, Zone: apodZone}
+}
+
+// nextCheck returns the moment after now that the next image is expected. That is
+// when the day after the current one has begun both in the APOD time zone and in
+// the local time zone of now, so that Today returns the new date.
+func nextCheck(now time.Time, zone *time.Location) time.Time {
+	day := NewADate(now.In(zone))
+	if local := NewADate(now); local.Before(day) {
+		day = local
+	}
+	y, m, d := day.Date().AddDate(0, 0, 1).Date()
+	published := time.Date(y, m, d, 0, 0, 0, 0, zone)
+	turned := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+	if published.Before(turned) {
+		published = turned
+	}
+	return published.Add(publishMargin).In(now.Location())
+}
+
+// rotate displays the next wallpaper according to the rotation.
+func (d *Daemon) rotate() error {
+	switch d.Rotation {
+	case rotateRandom:
+		return d.RandomArchive()
+	case rotateSequential:
+		err := d.Jump(1)
+		if err != errEndReached {
+			return err
+		}
+		all, err := d.eligible()
+		if err != nil {
+			return err
+		}
+		if len(all) == 0 {
+			return fmt.Errorf("No backgrounds downloaded yet")
+		}
+		return d.show(all[0])
+	}
+	return fmt.Errorf("Unknown rotation: %s", d.Rotation)
+}
+
+// Run checks for a new image at start and every time one is expected, and rotates
+// the wallpaper every Interval in between. It returns when ctx is done.
+func (d *Daemon) Run(ctx context.Context) error {
+	if d.Rotation != rotateRandom && d.Rotation != rotateSequential {
+		return fmt.Errorf("Unknown rotation: %s", d.Rotation)
+	}
+	var rotation <-chan time.Time
+	if d.Interval > 0 {
+		ticker := time.NewTicker(d.Interval)
+		defer ticker.Stop()
+		rotation = ticker.C
+	}
+	wait := d.check(ctx)
+	check := time.NewTimer(wait)
+	defer check.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			d.Log.Printf("Daemon stopped\n")
+			return nil
+		case <-rotation:
+			if err := withLock(d.rotate); err != nil {
+				d.Log.Printf("Could not rotate the wallpaper, because: %v\n", err)
+			}
+		case <-check.C:
+			check.Reset(d.check(ctx))
+		}
+	}
+}
+
+// check runs the login procedure and returns the time to wait for the next
+// check. If today's image could not be downloaded, that is sooner.
+func (d *Daemon) check(ctx context.Context) time.Duration {
+	now := d.Options.Clock.Now()
+	next := nextCheck(now, d.Zone)
+	loadErr, err := d.login(ctx)
+	if err == nil {
+		err = loadErr
+	}
+	if err != nil {
+		d.Log.Printf("Checking for a new image failed: %v\n", err)
+		if retry := now.Add(retryCheck); retry.Before(next) {
+			next = retry
+		}
+	}
+	d.Log.Printf("Next check for a new image at %s\n", next.Format(time.RFC1123))
+	return next.Sub(now)
+}
@@ -0,0 +1,107 @@
+package apod
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextCheck(t *testing.T) {
+	eastern := time.FixedZone("EDT", -4*60*60)
+	amsterdam := time.FixedZone("CEST", 2*60*60)
+	pacific := time.FixedZone("PDT", -7*60*60)
+	for _, c := range []struct {
+		now, expected time.Time
+	}{
+		// before APOD publishes, the local day has turned already
+		{time.Date(2014, 9, 21, 3, 0, 0, 0, amsterdam), time.Date(2014, 9, 21, 6, 10, 0, 0, amsterdam)},
+		{time.Date(2014, 9, 21, 7, 0, 0, 0, amsterdam), time.Date(2014, 9, 22, 6, 10, 0, 0, amsterdam)},
+		// APOD published, but Today is still yesterday
+		{time.Date(2014, 9, 20, 22, 0, 0, 0, pacific), time.Date(2014, 9, 21, 0, 10, 0, 0, pacific)},
+		{time.Date(2014, 9, 21, 1, 0, 0, 0, pacific), time.Date(2014, 9, 22, 0, 10, 0, 0, pacific)},
+		{time.Date(2014, 9, 21, 12, 0, 0, 0, eastern), time.Date(2014, 9, 22, 0, 10, 0, 0, eastern)},
+	} {
+		assert.Equal(t, c.expected, nextCheck(c.now, eastern), c.now.String())
+	}
+}
+
+func TestRotateSequential(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, f.Config, "140120", "140121")
+	makeStateFile(t, "140120", zoom)
+	d := NewDaemon(f, time.Hour, rotateSequential)
+	assert.NoError(t, d.rotate())
+	s, err := f.State()
+	assert.NoError(t, err)
+	assert.Equal(t, adate("140121"), s.DateCode)
+
+	assert.NoError(t, d.rotate())
+	s, err = f.State()
+	assert.NoError(t, err)
+	assert.Equal(t, adate("140120"), s.DateCode)
+}
+
+func TestDaemonUnknownRotation(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	err := NewDaemon(f, time.Hour, "backward").Run(context.Background())
+	assert.Equal(t, "Unknown rotation: backward", err.Error())
+}
+
+func TestDaemonStops(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, f.Config, "140120", "140121")
+	makeStateFile(t, "140120", fit)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, NewDaemon(f, time.Millisecond, rotateRandom).Run(ctx))
+	downloaded, err := f.downloadedOn(f.Today())
+	assert.NoError(t, err)
+	assert.True(t, downloaded)
+}
+
+// unpublishedServer answers 404 to the first request, as APOD does before
+// it publishes the page of the day, and serves the test data after that.
+type unpublishedServer struct {
+	mu        sync.Mutex
+	published bool
+}
+
+func (s *unpublishedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	published := s.published
+	s.published = true
+	s.mu.Unlock()
+	if !published {
+		http.NotFound(w, r)
+		return
+	}
+	http.FileServer(http.Dir("../testdata/apod.nasa.gov")).ServeHTTP(w, r)
+}
+
+func TestDaemonRetriesUnpublished(t *testing.T) {
+	server := httptest.NewServer(&unpublishedServer{})
+	defer server.Close()
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	f.APOD.Site = server.URL + "/"
+	makeTestWallpapers(t, f.Config, "140120")
+	makeStateFile(t, "140120", fit)
+	d := NewDaemon(f, 0, rotateRandom)
+	assert.True(t, d.check(context.Background()) <= retryCheck, "a failed download is retried within the hour")
+	downloaded, err := f.downloadedOn(f.Today())
+	assert.NoError(t, err)
+	assert.False(t, downloaded)
+
+	d.check(context.Background())
+	downloaded, err = f.downloadedOn(f.Today())
+	assert.NoError(t, err)
+	assert.True(t, downloaded)
+}
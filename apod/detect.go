@@ -0,0 +1,119 @@
+package apod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const autoSetterName = "auto"
+
+// desktop gives access to the parts of the environment the setter detection looks at.
+type desktop struct {
+	getenv    func(string) string
+	lookPath  func(string) (string, error)
+	processes func() []string
+}
+
+var hostDesktop = desktop{getenv: os.Getenv, lookPath: exec.LookPath, processes: runningProcesses}
+
+// desktopSetters maps the lower-cased names in XDG_CURRENT_DESKTOP and
+// DESKTOP_SESSION to setters.
+var desktopSetters = map[string]string{
+	"gnome":    "gsettings",
+	"ubuntu":   "gsettings",
+	"unity":    "gsettings",
+	"budgie":   "gsettings",
+	"pantheon": "gsettings",
+	"kde":      "kde",
+	"plasma":   "kde",
+	"xfce":     "xfce",
+	"lxde":     "pcmanfm",
+	"sway":     "sway",
+	"hyprland": "hyprpaper",
+}
+
+// processSetters maps the processes of desktop shells to setters, in order of preference.
+var processSetters = [][2]string{
+	{"plasmashell", "kde"},
+	{"gnome-shell", "gsettings"},
+	{"xfdesktop", "xfce"},
+	{"pcmanfm", "pcmanfm"},
+	{"sway", "sway"},
+	{"Hyprland", "hyprpaper"},
+}
+
+// setterBinaries names the program each setter needs.
+var setterBinaries = map[string]string{
+	"feh":        "feh",
+	"pcmanfm":    "pcmanfm",
+	"gsettings":  "gsettings",
+	"xfce":       "xfconf-query",
+	"kde":        "qdbus",
+	"sway":       "swaymsg",
+	"hyprpaper":  "hyprctl",
+	"xwallpaper": "xwallpaper",
+	"nitrogen":   "nitrogen",
+}
+
+// standaloneSetters are tried, in order, when no desktop environment is recognized.
+var standaloneSetters = []string{"feh", "xwallpaper", "nitrogen"}
+
+// runningProcesses lists the command names of the running processes.
+func runningProcesses() []string {
+	files, _ := filepath.Glob("/proc/[0-9]*/comm")
+	var names []string
+	for _, file := range files {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		names = append(names, strings.TrimSpace(string(bs)))
+	}
+	return names
+}
+
+// detectSetter picks the setter for the desktop d and explains the choice.
+func detectSetter(d desktop) (string, string, error) {
+	name, reason := detectDesktop(d)
+	if name != "" {
+		if _, err := d.lookPath(setterBinaries[name]); err != nil {
+			reason += fmt.Sprintf(", but %s was not found in PATH", setterBinaries[name])
+		}
+		return name, reason, nil
+	}
+	if wayland := d.getenv("WAYLAND_DISPLAY"); wayland != "" {
+		return "", "", fmt.Errorf("Could not detect the setter for the Wayland session on %s, please choose one of: %s", wayland, strings.Join(setterNames(), ", "))
+	}
+	for _, name := range standaloneSetters {
+		if path, err := d.lookPath(setterBinaries[name]); err == nil {
+			return name, fmt.Sprintf("no desktop environment was recognized and %s was found", path), nil
+		}
+	}
+	return "", "", fmt.Errorf("Could not detect the desktop environment and none of %s was found, please choose one of: %s", strings.Join(standaloneSetters, ", "), strings.Join(setterNames(), ", "))
+}
+
+// detectDesktop recognizes the desktop environment by its variables or processes.
+func detectDesktop(d desktop) (string, string) {
+	for _, variable := range []string{"XDG_CURRENT_DESKTOP", "DESKTOP_SESSION"} {
+		value := d.getenv(variable)
+		for _, part := range strings.Split(strings.ToLower(value), ":") {
+			if name, ok := desktopSetters[part]; ok {
+				return name, fmt.Sprintf("%s is %q", variable, value)
+			}
+		}
+	}
+	running := make(map[string]bool)
+	for _, p := range d.processes() {
+		running[p] = true
+	}
+	for _, ps := range processSetters {
+		if running[ps[0]] {
+			return ps[1], fmt.Sprintf("the %s process is running", ps[0])
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,71 @@
+package apod
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fakeDesktop(env map[string]string, binaries []string, processes ...string) desktop {
+	return desktop{
+		getenv: func(k string) string { return env[k] },
+		lookPath: func(name string) (string, error) {
+			for _, b := range binaries {
+				if b == name {
+					return "/usr/bin/" + name, nil
+				}
+			}
+			return "", fmt.Errorf("exec: %q: executable file not found in $PATH", name)
+		},
+		processes: func() []string { return processes },
+	}
+}
+
+func TestDetectSetter(t *testing.T) {
+	all := []string{"feh", "gsettings", "xfconf-query", "qdbus", "swaymsg", "hyprctl", "pcmanfm"}
+	for _, c := range []struct {
+		d      desktop
+		setter string
+		reason string
+	}{
+		{fakeDesktop(map[string]string{"XDG_CURRENT_DESKTOP": "ubuntu:GNOME"}, all), "gsettings", `XDG_CURRENT_DESKTOP is "ubuntu:GNOME"`},
+		{fakeDesktop(map[string]string{"XDG_CURRENT_DESKTOP": "KDE"}, all), "kde", `XDG_CURRENT_DESKTOP is "KDE"`},
+		{fakeDesktop(map[string]string{"DESKTOP_SESSION": "xfce"}, all), "xfce", `DESKTOP_SESSION is "xfce"`},
+		{fakeDesktop(map[string]string{"WAYLAND_DISPLAY": "wayland-1"}, all, "Hyprland"), "hyprpaper", "the Hyprland process is running"},
+		{fakeDesktop(nil, all, "bash", "pcmanfm"), "pcmanfm", "the pcmanfm process is running"},
+		{fakeDesktop(nil, []string{"nitrogen", "xwallpaper"}), "xwallpaper", "no desktop environment was recognized and /usr/bin/xwallpaper was found"},
+		{fakeDesktop(map[string]string{"XDG_CURRENT_DESKTOP": "sway"}, nil), "sway", `XDG_CURRENT_DESKTOP is "sway", but swaymsg was not found in PATH`},
+	} {
+		setter, reason, err := detectSetter(c.d)
+		assert.NoError(t, err)
+		assert.Equal(t, c.setter, setter)
+		assert.Equal(t, c.reason, reason)
+	}
+}
+
+func TestDetectSetterFails(t *testing.T) {
+	_, _, err := detectSetter(fakeDesktop(map[string]string{"WAYLAND_DISPLAY": "wayland-0"}, []string{"feh"}))
+	assert.Contains(t, err.Error(), "Could not detect the setter for the Wayland session on wayland-0")
+	_, _, err = detectSetter(fakeDesktop(nil, nil))
+	assert.Contains(t, err.Error(), "Could not detect the desktop environment and none of feh, xwallpaper, nitrogen was found")
+}
+
+func TestConfigureAuto(t *testing.T) {
+	f, testHome := frontendForTest(t)
+	defer cleanUp(t, testHome)
+	f.desktop = fakeDesktop(map[string]string{"XDG_CURRENT_DESKTOP": "XFCE"}, []string{"xfconf-query"})
+	assert.NoError(t, f.configure(autoSetterName))
+	assert.Equal(t, "xfce", f.Config.Setter)
+}
+
+func TestConfigureAutoLXDE(t *testing.T) {
+	f, testHome := frontendForTest(t)
+	defer cleanUp(t, testHome)
+	f.desktop = fakeDesktop(map[string]string{"XDG_CURRENT_DESKTOP": "LXDE"}, []string{"pcmanfm"})
+	assert.NoError(t, f.configure(autoSetterName))
+	assert.Equal(t, "pcmanfm", f.Config.Setter)
+	being, err := exists(autostartFile())
+	assert.NoError(t, err)
+	assert.True(t, being, "the LXDE autostart entry should be written")
+}
@@ -0,0 +1,235 @@
+package apod
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// partSuffix marks a download in progress.
+	partSuffix            = ".part"
+	defaultRequestTimeout = 2 * time.Minute
+)
+
+// Fetcher does the HTTP requests for the sources, it is polite to their hosts
+// and retries transient failures.
+type Fetcher struct {
+	Client *http.Client
+	// Delay is the minimum time between two requests to the same host.
+	Delay time.Duration
+	// Retries is the number of times a download is retried after a transient failure.
+	Retries int
+	// Backoff is the wait before the first retry, it doubles for every next retry.
+	Backoff time.Duration
+	// Timeout limits every single request, including reading its body.
+	Timeout time.Duration
+	mu      sync.Mutex
+	next    map[string]time.Time
+}
+
+// NewFetcher constructs a Fetcher using the default HTTP client.
+func NewFetcher() *Fetcher {
+	return &Fetcher{
+		Client:  http.DefaultClient,
+		Retries: 3,
+		Backoff: time.Second,
+		Timeout: defaultRequestTimeout,
+		next:    make(map[string]time.Time),
+	}
+}
+
+// delayKey is the key of the delay given by withDelay.
+type delayKey struct{}
+
+// withDelay returns a context in which the fetchers keep delay between two
+// requests to the same host, instead of their Delay.
+func withDelay(ctx context.Context, delay time.Duration) context.Context {
+	return context.WithValue(ctx, delayKey{}, delay)
+}
+
+// wait blocks until a request to the host of rawurl is allowed by Delay, or
+// by the delay of ctx, or until ctx is done.
+func (a *Fetcher) wait(ctx context.Context, rawurl string) error {
+	delay := a.Delay
+	if d, ok := ctx.Value(delayKey{}).(time.Duration); ok {
+		delay = d
+	}
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ctx.Err()
+	}
+	a.mu.Lock()
+	now := time.Now()
+	at := a.next[u.Host]
+	if at.Before(now) {
+		at = now
+	}
+	a.next[u.Host] = at.Add(delay)
+	a.mu.Unlock()
+	return sleep(ctx, at.Sub(now))
+}
+
+// sleep waits for d, or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// request starts a request to url with the Timeout of the fetcher. The
+// returned cancel func must be called after the body was read.
+func (a *Fetcher) request(ctx context.Context, url string) (*http.Request, context.CancelFunc, error) {
+	cancel := func() {}
+	if a.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, a.Timeout)
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return req.WithContext(ctx), cancel, nil
+}
+
+// body closes the response body and ends its request.
+type body struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b body) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// get requests url, any status but 200 OK is an error.
+func (a *Fetcher) get(ctx context.Context, url string) (*http.Response, error) {
+	if err := a.wait(ctx, url); err != nil {
+		return nil, err
+	}
+	req, cancel, err := a.request(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = body{resp.Body, cancel}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("Getting %s returned status: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// transientError is a failure that may go away when retried.
+type transientError struct {
+	error
+}
+
+// Download fetches the url argument and stores the result in the path in the file argument.
+// The data is written to a partial file first, which is renamed to file when complete.
+// Transient failures are retried with exponential backoff, resuming the partial file
+// where the server supports it. When ctx is done the partial file is kept for a
+// later resume.
+func (a *Fetcher) Download(ctx context.Context, file, url string) error {
+	part := file + partSuffix
+	wait := a.Backoff
+	for attempt := 0; ; attempt++ {
+		err := a.download(ctx, part, url)
+		if err == nil {
+			return os.Rename(part, file)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if _, ok := err.(transientError); !ok {
+			os.Remove(part)
+			return err
+		}
+		if attempt >= a.Retries {
+			return err
+		}
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+		wait *= 2
+	}
+}
+
+// download does a single attempt at getting url into part, continuing a
+// previous partial download if present.
+func (a *Fetcher) download(ctx context.Context, part, url string) error {
+	var offset int64
+	if fi, err := os.Stat(part); err == nil {
+		offset = fi.Size()
+	}
+	if err := a.wait(ctx, url); err != nil {
+		return err
+	}
+	req, cancel, err := a.request(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return transientError{err}
+	}
+	defer resp.Body.Close()
+	flags := os.O_WRONLY | os.O_CREATE
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		var start int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-", &start); err != nil || start != offset {
+			os.Remove(part)
+			return transientError{fmt.Errorf("Getting %s returned unexpected range: %q", url, resp.Header.Get("Content-Range"))}
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return nil
+		}
+		os.Remove(part)
+		return transientError{fmt.Errorf("Getting %s returned status: %s", url, resp.Status)}
+	default:
+		err := fmt.Errorf("Getting %s returned status: %s", url, resp.Status)
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			return transientError{err}
+		}
+		return err
+	}
+	output, err := os.OpenFile(part, flags, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(output, resp.Body)
+	if cerr := output.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return transientError{err}
+	}
+	return nil
+}
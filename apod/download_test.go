@@ -0,0 +1,176 @@
+package apod
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServer serves content, but drops the connection halfway the body for
+// the first drops requests, and answers with status for the next fails requests.
+type flakyServer struct {
+	content []byte
+	drops   int
+	fails   int
+	status  int
+	mu      sync.Mutex
+	ranges  []string
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.ranges = append(s.ranges, r.Header.Get("Range"))
+	drop := s.drops > 0
+	if drop {
+		s.drops--
+	}
+	fail := !drop && s.fails > 0
+	if fail {
+		s.fails--
+	}
+	s.mu.Unlock()
+	if fail {
+		w.WriteHeader(s.status)
+		return
+	}
+	if drop {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(s.content[:len(s.content)/2])
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			conn.Close()
+		}
+		return
+	}
+	http.ServeContent(w, r, "image.jpg", time.Time{}, bytes.NewReader(s.content))
+}
+
+// stallingServer serves half of content and then stalls until the client gives
+// up, for the first stalls requests.
+type stallingServer struct {
+	content []byte
+	stalls  int
+	mu      sync.Mutex
+}
+
+func (s *stallingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	stall := s.stalls > 0
+	s.stalls--
+	s.mu.Unlock()
+	if !stall {
+		http.ServeContent(w, r, "image.jpg", time.Time{}, bytes.NewReader(s.content))
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(s.content)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(s.content[:len(s.content)/2])
+	w.(http.Flusher).Flush()
+	<-r.Context().Done()
+}
+
+func testImage(t testing.TB) []byte {
+	bs, err := ioutil.ReadFile("../testdata/apod.nasa.gov/apod/image/1409/m8_chua_2500.jpg")
+	assert.NoError(t, err)
+	return bs
+}
+
+func downloadForTest(t *testing.T, s *flakyServer) (string, error) {
+	server := httptest.NewServer(s)
+	defer server.Close()
+	testHome := setupTestHome(t)
+	a := NewAPOD()
+	a.Backoff = time.Millisecond
+	file := filepath.Join(testHome, "image")
+	return testHome, a.Download(context.Background(), file, server.URL+"/image.jpg")
+}
+
+func TestDownloadResumesDroppedConnection(t *testing.T) {
+	s := &flakyServer{content: testImage(t), drops: 1}
+	testHome, err := downloadForTest(t, s)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, err)
+	bs, err := ioutil.ReadFile(filepath.Join(testHome, "image"))
+	assert.NoError(t, err)
+	assert.Equal(t, s.content, bs)
+	assert.Equal(t, []string{"", "bytes=687-"}, s.ranges)
+	present, err := exists(filepath.Join(testHome, "image"+partSuffix))
+	assert.NoError(t, err)
+	assert.False(t, present)
+}
+
+func TestDownloadRetriesServerErrors(t *testing.T) {
+	s := &flakyServer{content: testImage(t), fails: 2, status: http.StatusServiceUnavailable}
+	testHome, err := downloadForTest(t, s)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(s.ranges))
+}
+
+func TestDownloadGivesUp(t *testing.T) {
+	s := &flakyServer{content: testImage(t), drops: 1, fails: 3, status: http.StatusServiceUnavailable}
+	testHome, err := downloadForTest(t, s)
+	defer cleanUp(t, testHome)
+	assert.Contains(t, err.Error(), "503 Service Unavailable")
+	assert.Equal(t, 4, len(s.ranges))
+	present, err := exists(filepath.Join(testHome, "image"))
+	assert.NoError(t, err)
+	assert.False(t, present)
+	part, err := ioutil.ReadFile(filepath.Join(testHome, "image"+partSuffix))
+	assert.NoError(t, err)
+	assert.Equal(t, s.content[:len(s.content)/2], part)
+}
+
+func TestDownloadDoesNotRetryNotFound(t *testing.T) {
+	s := &flakyServer{content: testImage(t), fails: 1, status: http.StatusNotFound}
+	testHome, err := downloadForTest(t, s)
+	defer cleanUp(t, testHome)
+	assert.Contains(t, err.Error(), "404 Not Found")
+	assert.Equal(t, 1, len(s.ranges))
+	names, err := ioutil.ReadDir(testHome)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(names))
+}
+
+func TestDownloadRequestTimeout(t *testing.T) {
+	s := &stallingServer{content: testImage(t), stalls: 1}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	testHome := setupTestHome(t)
+	defer cleanUp(t, testHome)
+	a := NewAPOD()
+	a.Backoff = time.Millisecond
+	a.Timeout = 50 * time.Millisecond
+	file := filepath.Join(testHome, "image")
+	assert.NoError(t, a.Download(context.Background(), file, server.URL+"/image.jpg"))
+	bs, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, s.content, bs)
+}
+
+func TestDownloadCancelledKeepsPart(t *testing.T) {
+	s := &stallingServer{content: testImage(t), stalls: 1}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	testHome := setupTestHome(t)
+	defer cleanUp(t, testHome)
+	a := NewAPOD()
+	file := filepath.Join(testHome, "image")
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, a.Download(ctx, file, server.URL+"/image.jpg"))
+	part, err := ioutil.ReadFile(file + partSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, s.content[:len(s.content)/2], part)
+}
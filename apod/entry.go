@@ -0,0 +1,232 @@
+package apod
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Entry holds the metadata found on an APOD page, or the equivalent
+// from other sources.
+type Entry struct {
+	// Source is the name of the source the entry comes from.
+	Source      string
+	Date        ADate
+	Title       string
+	Credit      string
+	Copyright   bool
+	Explanation string
+	Keywords    []string
+	// URL is the address of the page describing the entry.
+	URL string
+	// Image is the inline, low resolution image.
+	Image string
+	// HiRes is the high resolution image the inline image links to.
+	HiRes string
+	// Video is the embedded video, if any.
+	Video string
+	// Poster is the still image given with an embedded video file, if any.
+	Poster string
+	// Thumbnail is the image the page gives to represent it, if any.
+	Thumbnail string
+	// VideoStill marks that the image downloaded is a still of Video.
+	VideoStill bool
+	// Width and Height are the size of the image downloaded.
+	Width, Height int
+}
+
+// Entry loads and parses the APOD page for the given date.
+func (a *APOD) Entry(ctx context.Context, date ADate) (*Entry, error) {
+	pageURL := a.UrlForDate(date)
+	resp, err := a.get(ctx, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	e, err := parseEntry(resp.Body, base)
+	if err != nil {
+		return nil, err
+	}
+	e.Source = a.Name()
+	e.Date = date
+	e.URL = pageURL
+	return e, nil
+}
+
+// Wallpaper returns the key of the image of the entry.
+func (e *Entry) Wallpaper() Wallpaper {
+	return Wallpaper{Source: e.Source, Date: e.Date}
+}
+
+// sections of an APOD page whose text is collected
+const (
+	noSection = iota
+	titleSection
+	creditSection
+	explanationSection
+)
+
+// parseEntry tokenizes an APOD page, relative links are resolved against base.
+func parseEntry(r io.Reader, base *url.URL) (*Entry, error) {
+	e := new(Entry)
+	z := html.NewTokenizer(r)
+	var (
+		section int
+		text    strings.Builder
+		bold    strings.Builder
+		inBold  bool
+		link    string
+	)
+	resolve := func(ref string) string {
+		u, err := url.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			return ""
+		}
+		return base.ResolveReference(u).String()
+	}
+	finish := func() {
+		s := collapse(text.String())
+		switch section {
+		case titleSection:
+			e.Title = pageTitle(s)
+		case creditSection:
+			e.Credit = s
+		case explanationSection:
+			e.Explanation = s
+		}
+		section = noSection
+		text.Reset()
+	}
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if z.Err() != io.EOF {
+				return nil, z.Err()
+			}
+			finish()
+			return e, nil
+		case html.TextToken:
+			t := string(z.Text())
+			if inBold {
+				bold.WriteString(t)
+			}
+			if section != noSection {
+				text.WriteString(t)
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "title":
+				finish()
+				section = titleSection
+			case "meta":
+				if attr(tok, "property") == "og:image" && e.Thumbnail == "" {
+					e.Thumbnail = resolve(attr(tok, "content"))
+				}
+				if attr(tok, "name") == "keywords" {
+					for _, k := range strings.Split(attr(tok, "content"), ",") {
+						if k = strings.TrimSpace(k); k != "" {
+							e.Keywords = append(e.Keywords, k)
+						}
+					}
+				}
+			case "a":
+				link = attr(tok, "href")
+			case "img":
+				if e.Image == "" {
+					e.Image = resolve(attr(tok, "src"))
+					if isImageLink(link) {
+						e.HiRes = resolve(link)
+					}
+				}
+			case "video":
+				if e.Poster == "" && attr(tok, "poster") != "" {
+					e.Poster = resolve(attr(tok, "poster"))
+				}
+				if e.Video == "" && attr(tok, "src") != "" {
+					e.Video = resolve(attr(tok, "src"))
+				}
+			case "iframe", "embed", "source":
+				if e.Video == "" && attr(tok, "src") != "" {
+					e.Video = resolve(attr(tok, "src"))
+				}
+			case "b":
+				inBold = true
+				bold.Reset()
+			case "p", "center":
+				if section == explanationSection {
+					finish()
+				}
+			}
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "title":
+				finish()
+			case "a":
+				link = ""
+			case "b":
+				inBold = false
+				label := collapse(bold.String())
+				switch {
+				case section == noSection && strings.Contains(label, "Credit"):
+					section = creditSection
+					e.Copyright = strings.Contains(label, "Copyright")
+				case section == noSection && strings.HasPrefix(label, "Explanation"):
+					section = explanationSection
+				}
+			case "center":
+				if section == creditSection {
+					finish()
+				}
+			}
+		}
+	}
+}
+
+// isImageLink tells whether href points at an image file rather than a page.
+func isImageLink(href string) bool {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return false
+	}
+	switch strings.ToLower(path.Ext(u.Path)) {
+	case ".jpg", ".gif", ".png":
+		return true
+	}
+	return false
+}
+
+func attr(t html.Token, key string) string {
+	for _, a := range t.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// collapse replaces all runs of white space by a single space.
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// pageTitle strips the "APOD: 2014 September 21 - " prefix from a page title.
+func pageTitle(s string) string {
+	if !strings.HasPrefix(s, "APOD:") {
+		return s
+	}
+	if i := strings.Index(s, " - "); i >= 0 {
+		return s[i+len(" - "):]
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package apod
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var entryTests = []struct {
+	page        string
+	title       string
+	credit      string
+	copyright   bool
+	explanation string
+	keywords    []string
+	image       string
+	hiRes       string
+	video       string
+}{
+	{
+		page:        "ap140920.html",
+		title:       "Shoreline of the Universe",
+		credit:      "Bill Dickinson",
+		copyright:   true,
+		explanation: "Against dark rifts of interstellar dust, the ebb and flow of starlight",
+		keywords:    []string{"monochrome", "milky way"},
+		image:       "image/1409/ShorelineoftheUniverse1024.jpg",
+		hiRes:       "image/1409/ShorelineoftheUniverse.jpg",
+	},
+	{
+		page:        "ap140921.html",
+		title:       "Saturn at Equinox",
+		credit:      "Cassini Imaging Team, ISS, JPL, ESA, NASA",
+		explanation: "How would Saturn look if its ring plane pointed right at the Sun? Before August 2009, nobody knew.",
+		keywords:    []string{"Saturn", "equinox", "rings"},
+		image:       "image/1409/saturnequinox_cassini_960.jpg",
+		hiRes:       "image/1409/saturnequinox_cassini_7227.jpg",
+	},
+	{
+		page:        "ap140922.html",
+		title:       "Earth at Equinox",
+		credit:      "Roscosmos / NTSOMZ / zelenyikot.livejournal.com Courtesy: Igor Tirsky, Vitaliy Egorov",
+		explanation: "Earth is at equinox.",
+		keywords:    []string{"Earth", "equinox", "time lapse"},
+		video:       "http://www.youtube.com/embed/y4er-S_lNRs?rel=0",
+	},
+	{
+		page:        "ap140923.html",
+		title:       "Aurora and Volcanic Light Pillar",
+		credit:      "Stéphane Vetter (Nuits sacrées)",
+		copyright:   true,
+		explanation: "That's no sunset. And that thin red line just above it -- that's not a sun pillar.",
+		keywords:    []string{"light pillar", "volcano", "aurora"},
+		image:       "image/1409/volcanicpillar_vetter_960.jpg",
+		hiRes:       "image/1409/volcanicpillar_vetter_1400.jpg",
+	},
+	{
+		page:        "ap140924.html",
+		title:       "The Lagoon Nebula in Stars Dust and Gas",
+		credit:      "Remus Chua (Celestial Portraits)",
+		copyright:   true,
+		explanation: "The large majestic",
+		keywords:    []string{"M8", "Lagoon nebula", "emission nebula"},
+		image:       "image/1409/m8_chua_960.jpg",
+		hiRes:       "image/1409/m8_chua_2500.jpg",
+	},
+	{
+		page:        "ap141013.html",
+		title:       "Sprite Lightning in Slow Motion",
+		credit:      "H. H. C. Stenbaek-Nielsen (U. Alaska, Fairbanks), DARPA, NSF",
+		explanation: "What causes sprite lightning?",
+		keywords:    []string{"sprite", "lightning", "slow motion"},
+		video:       "http://www.youtube.com/embed/i3StAXEbGSM?rel=0&controls=0",
+	},
+}
+
+func TestParseEntry(t *testing.T) {
+	base, err := url.Parse(testAPODSite + "apod/")
+	assert.NoError(t, err)
+	prefixed := func(s string) string {
+		if s == "" {
+			return ""
+		}
+		return testAPODSite + "apod/" + s
+	}
+	for _, et := range entryTests {
+		fd, err := os.Open("../testdata/apod.nasa.gov/apod/" + et.page)
+		assert.NoError(t, err)
+		e, err := parseEntry(fd, base)
+		fd.Close()
+		assert.NoError(t, err, et.page)
+		assert.Equal(t, et.title, e.Title, et.page)
+		assert.Equal(t, et.credit, e.Credit, et.page)
+		assert.Equal(t, et.copyright, e.Copyright, et.page)
+		assert.True(t, strings.HasPrefix(e.Explanation, et.explanation), "%s: %q", et.page, e.Explanation)
+		assert.Equal(t, et.keywords, e.Keywords, et.page)
+		assert.Equal(t, prefixed(et.image), e.Image, et.page)
+		assert.Equal(t, prefixed(et.hiRes), e.HiRes, et.page)
+		assert.Equal(t, et.video, e.Video, et.page)
+	}
+}
+
+func TestParseEntryExplanationEnd(t *testing.T) {
+	base, err := url.Parse(testAPODSite + "apod/")
+	assert.NoError(t, err)
+	fd, err := os.Open("../testdata/apod.nasa.gov/apod/ap140921.html")
+	assert.NoError(t, err)
+	defer fd.Close()
+	e, err := parseEntry(fd, base)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(e.Explanation, "This week, Earth undergoes an equinox."), e.Explanation)
+}
+
+func TestParseEntryPageLink(t *testing.T) {
+	base, err := url.Parse(testAPODSite + "apod/")
+	assert.NoError(t, err)
+	e, err := parseEntry(strings.NewReader(`<center><a href="image/2301/interactive.html">`+
+		`<img src="image/2301/small.jpg"></a></center>`), base)
+	assert.NoError(t, err)
+	assert.Equal(t, testAPODSite+"apod/image/2301/small.jpg", e.Image)
+	assert.Equal(t, "", e.HiRes)
+}
+
+func TestEntry(t *testing.T) {
+	a := testAPOD()
+	e, err := a.Entry(context.Background(), adate(testDateSeptember))
+	assert.NoError(t, err)
+	assert.Equal(t, adate(testDateSeptember), e.Date)
+	assert.Equal(t, a.UrlForDate(adate(testDateSeptember)), e.URL)
+	assert.Equal(t, "The Lagoon Nebula in Stars Dust and Gas", e.Title)
+	assert.Equal(t, a.Site+"apod/image/1409/m8_chua_2500.jpg", e.HiRes)
+}
+
+func TestEntryNotFound(t *testing.T) {
+	a := testAPOD()
+	_, err := a.Entry(context.Background(), adate("130101"))
+	assert.Equal(t, "Getting http://localhost:8765/apod/ap130101.html returned status: 404 Not Found", err.Error())
+}
@@ -1,38 +1,21 @@
 package apod
 
 import (
+	"context"
 	"encoding/json"
-	"flag"
 	"fmt"
+	"image"
 	"io"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"time"
+	"sort"
 
 	"github.com/haklop/gnotifier"
 	"github.com/skratchdot/open-golang/open"
 )
 
-var (
-	info         = flag.Bool("info", false, "open the APOD-page on the current background")
-	login        = flag.Bool("login", false, "do the procedure for APOD graphical login: download todays image and display it")
-	logFileFlag  = flag.String("log", "", "logfile specification")
-	days         = flag.Int("fetch", 0, "days to go back downloading")
-	jump         = flag.Int("jump", 0, "jump N backgrounds further, use negative numbers to jump backward")
-	configFlag   = flag.String("config", "", "initializes apod-bg for chosen window-manager")
-	unconfigFlag = flag.Bool("unconfig", false, "removes the autostart entry for LXDE")
-	apodFlag     = flag.Bool("apod", false, "opens the default browser on the Astronomy Picture of The Day")
-	mode         = flag.Bool("mode", false, "mode background sizing options: fit or zoom")
-	nonotify     = flag.Bool("nonotify", false, "do not send notifications to the desktop")
-	noseed       = flag.Bool("noseed", false, "do not seed after configuring")
-	dateFlag     = flag.String("date", "", "specify a date to be considered as now (for testing)")
-	randomFlag   = flag.Bool("random", false, "pick a random archive picture")
-)
-
 const (
 	stateFileBasename  = "now-showing"
 	configFileBasename = "config.json"
@@ -44,41 +27,17 @@ const apodDesktop = `[Desktop Entry]
 
 Type=Application
 
-Exec=apod-bg -login
+Exec=apod-bg login
 `
 
-const setScriptBareWM = `#!/bin/bash
-if test $WALLPAPER_OPTIONS = zoom; then
-	feh --bg-fill "$WALLPAPER"
-else
-	feh --bg-max "$WALLPAPER"
-fi
-`
-const setScriptLXDE = `#!/bin/bash
-if test $WALLPAPER_OPTIONS = zoom; then
-	pcmanfm --set-wallpaper="$WALLPAPER" --wallpaper-mode=crop
-else
-	pcmanfm --set-wallpaper="$WALLPAPER" --wallpaper-mode=fit
-fi
-`
+const configNotFound = "configuration file was not found. Please run apod-bg config SETTER first, see man page for more information."
 
-const setScriptGNOME = `#!/bin/bash
-gsettings set org.gnome.desktop.background picture-uri "file://$WALLPAPER"
-if test $WALLPAPER_OPTIONS = zoom; then
-	gsettings set  org.gnome.desktop.background picture-options zoom
-else
-	gsettings set  org.gnome.desktop.background picture-options scaled
-fi
-gsettings set  org.gnome.desktop.background primary-color "000000"
-gsettings set  org.gnome.desktop.background secondary-color "000000"
-`
-const configNotFound = "configuration file was not found. Please run apod-bg -config=barewm|gnome|lxde> first, see man page for more information."
-
-func logFile() string {
-	if *logFileFlag == "" {
+// logFile returns the log file to use, path if it is given.
+func logFile(path string) string {
+	if path == "" {
 		return os.ExpandEnv("${HOME}/.config/apod-bg/apod-bg.log")
 	}
-	return *logFileFlag
+	return path
 }
 
 func configDir() string {
@@ -109,9 +68,28 @@ type logger interface {
 	Printf(f string, i ...interface{})
 }
 
-// config sets where to find the wallpaper directory.
+// config sets where to find the wallpaper directory, where to get the images from
+// and how to set them.
 type config struct {
 	WallpaperDir string
+	// Sources names the image sources, only apod if empty.
+	Sources []string
+	// Setter names the wallpaper setter, the set-wallpaper.sh script if empty.
+	Setter string
+	// Monitors is same, different or span, same if empty.
+	Monitors string
+	// Render is blur, smartcrop or center to hand the setter a rendition at
+	// the size of the screen, the downloaded image is used if empty.
+	Render string
+	// Caption has the title, date and credit drawn on the renditions, if set.
+	Caption *caption
+	// Mode is fit, zoom or auto for the images shown, empty keeps the
+	// options of the image shown before.
+	Mode string
+	// AutoCrop is the percentage of an image zoom may cut off in auto mode, 15 if zero.
+	AutoCrop float64
+	// Rules restrict the images jump and random show, all of them if empty.
+	Rules []rule
 }
 
 func (c *config) writeOut() error {
@@ -119,19 +97,32 @@ func (c *config) writeOut() error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(configFile(), bs, 0644)
+	return writeFileAtomic(configFile(), bs, 0644)
 }
 
 func (c *config) makeWallpaperDir() error {
 	return os.MkdirAll(c.WallpaperDir, 0700)
 }
 
-func (c *config) fileName(isodate ADate) string {
-	return filepath.Join(c.WallpaperDir, c.fileBaseName(isodate))
+// fileName returns the image file of the wallpaper, with the extension of its
+// type. Images downloaded by earlier versions, and images not downloaded yet,
+// have no extension.
+func (c *config) fileName(w Wallpaper) string {
+	base := filepath.Join(c.WallpaperDir, c.fileBaseName(w))
+	for _, ext := range imageExtensions {
+		if present, _ := exists(base + ext); present {
+			return base + ext
+		}
+	}
+	return base
+}
+
+func (c *config) fileBaseName(w Wallpaper) string {
+	return fmt.Sprintf("%s"+imgInfix+"%s", w.Source, w.Date)
 }
 
-func (c *config) fileBaseName(isodate ADate) string {
-	return fmt.Sprintf(imgPrefix+"%s", isodate.String())
+func (c *config) metaFileName(w Wallpaper) string {
+	return filepath.Join(c.WallpaperDir, fmt.Sprintf("%s"+metaInfix+"%s.json", w.Source, w.Date))
 }
 
 func MakeConfigDir() error {
@@ -160,36 +151,83 @@ func (n *Notifier) Notify(mesg string) {
 }
 
 type Frontend struct {
-	Log    logger
-	Config *config
+	Options Options
+	Log     logger
+	Config  *config
 	Notifier
 	APOD    *APOD
 	loader  *Loader
 	storage *Storage
-}
-
-func NewFrontend(logger logger, notifier Notifier) *Frontend {
+	// run runs the commands of the wallpaper setters
+	run runner
+	// desktop is inspected by -config=auto
+	desktop desktop
+	// keep holds the state in memory for the server, it is reread when the
+	// state file changes
+	keep        bool
+	current     *State
+	currentRead os.FileInfo
+	// rotation caches the matches of the search the rotation is restricted to
+	rotation *rotationMatches
+}
+
+// NewFrontend constructs a frontend with the given options.
+func NewFrontend(logger logger, notifier Notifier, opts Options) *Frontend {
+	if opts.Clock == nil {
+		opts.Clock = SystemClock
+	}
+	if opts.LoginTimeout <= 0 {
+		opts.LoginTimeout = defaultLoginTimeout
+	}
 	APOD := NewAPOD()
 	s := &Storage{}
-	l := &Loader{APOD: APOD, logger: logger, Notifier: notifier}
-	return &Frontend{
+	l := &Loader{Sources: []Source{APOD}, Workers: 4, Thumbnails: NewThumbnails(APOD.Fetcher), logger: logger, Notifier: notifier}
+	f := &Frontend{
+		Options:  opts,
 		Log:      logger,
 		Notifier: notifier,
 		APOD:     APOD,
 		Config:   new(config),
 		loader:   l,
-		storage:  s}
-
+		storage:  s,
+		run:      execRunner,
+		desktop:  hostDesktop}
+	l.Clock = optionsClock{&f.Options}
+	return f
 }
 
-// State defines the date of the apod-image being shown and display options
+// State defines the date and source of the image being shown and display options
 type State struct {
 	DateCode ADate
-	Options  string
+	// Source is the name of the source of the image, empty means apod.
+	Source  string
+	Options string
+	// Outputs holds what each monitor shows, if they show different images.
+	Outputs map[string]State `json:",omitempty"`
+}
+
+// newState returns the state showing w with the given options.
+func newState(w Wallpaper, options string) State {
+	return State{DateCode: w.Date, Source: w.Source, Options: options}
+}
+
+// Wallpaper returns the image being shown.
+func (s State) Wallpaper() Wallpaper {
+	if s.Source == "" {
+		return Wallpaper{Source: apodName, Date: s.DateCode}
+	}
+	return Wallpaper{Source: s.Source, Date: s.DateCode}
 }
 
 // State returns the current State-struct read from disk, or APOD new State object set to today if there is no state file
 func (f *Frontend) State() (State, error) {
+	var read os.FileInfo
+	if f.keep {
+		read, _ = os.Stat(stateFile())
+		if f.current != nil && unchanged(read, f.currentRead) {
+			return *f.current, nil
+		}
+	}
 	present, err := exists(stateFile())
 	if err != nil {
 		return State{}, err
@@ -203,18 +241,42 @@ func (f *Frontend) State() (State, error) {
 	}
 	var s State
 	err = json.Unmarshal(sfb, &s)
-	return s, err
+	if err != nil || s.DateCode.IsZero() {
+		s, err = f.recoverState(err)
+		if err != nil {
+			return State{}, err
+		}
+	}
+	if f.keep {
+		f.current, f.currentRead = &s, read
+	}
+	return s, nil
+}
+
+// recoverState returns the state to fall back to when the state file is
+// corrupt: the newest wallpaper, or today if there is none.
+func (f *Frontend) recoverState(cause error) (State, error) {
+	if cause == nil {
+		cause = fmt.Errorf("no date")
+	}
+	s := State{DateCode: f.Today(), Options: fit}
+	all, err := f.storage.DownloadedWallpapers()
+	if err != nil {
+		return State{}, err
+	}
+	if len(all) > 0 {
+		s = newState(all[len(all)-1], fit)
+	}
+	f.Log.Printf("The state file %s is corrupt (%v), falling back to %s\n", stateFile(), cause, s.Wallpaper())
+	return s, nil
 }
 
 func store(s State) error {
-	fd, err := os.Create(stateFile())
+	bs, err := json.Marshal(s)
 	if err != nil {
 		return err
 	}
-	defer fd.Close()
-	e := json.NewEncoder(fd)
-	err = e.Encode(s)
-	return err
+	return writeFileAtomic(stateFile(), append(bs, '\n'), 0644)
 }
 
 func (f *Frontend) writeAutostart() error {
@@ -229,35 +291,55 @@ func (f *Frontend) removeAutostart() error {
 	return os.Remove(autostartFile())
 }
 
-func (f *Frontend) Seed() error {
-	if *noseed {
+// Seed downloads the latest images and shows one at random. It holds the
+// lock only while it sets the wallpaper, so it must be called without it.
+func (f *Frontend) Seed(ctx context.Context) error {
+	if f.Options.NoSeed {
 		return nil
 	}
 	date := f.Today()
 	for i := 0; i < 7; i++ {
-		loaded, err := f.loader.Download(date)
-		date = *date.Back()
-		if err != nil {
-			continue
-		}
-		if loaded {
+		loaded, _ := f.loader.DownloadDay(ctx, date)
+		date = date.Back()
+		if len(loaded) > 0 {
 			break
 		}
 	}
-	return f.RandomArchive()
+	return withLock(f.RandomArchive)
 }
 
 // Today returns the date of today in APOD formatted string.
 func (f *Frontend) Today() ADate {
-	if *dateFlag != "" {
-		return ADate(*dateFlag)
+	return NewADate(f.Options.Clock.Now())
+}
+
+// validateDate checks a date given by the user against clock.
+func validateDate(s string, clock Clock) error {
+	d, err := ParseADate(s)
+	if err != nil {
+		return err
 	}
-	return NewADate(time.Now())
+	return d.Validate(NewADate(clock.Now()))
 }
 
 // configure initializes the configuration according the config argument.
 func (f *Frontend) configure(cfg string) error {
 	f.Config = new(config)
+	if cfg == autoSetterName {
+		name, reason, err := detectSetter(f.desktop)
+		if err != nil {
+			return err
+		}
+		f.Log.Printf("Chose the %s setter, because %s\n", name, reason)
+		cfg = name
+	}
+	f.Config.Setter = cfg
+	if alias, ok := setterAliases[cfg]; ok {
+		f.Config.Setter = alias
+	}
+	if _, ok := setters[f.Config.Setter]; !ok {
+		return fmt.Errorf("Unknown configuration type: %s\n", cfg)
+	}
 	{
 		err := MakeConfigDir()
 		if err != nil {
@@ -275,35 +357,36 @@ func (f *Frontend) configure(cfg string) error {
 			return err
 		}
 	}
-	script := ""
-	switch cfg {
-	case "barewm":
-		script = setScriptBareWM
-	case "lxde":
-		script = setScriptLXDE
+	// The pcmanfm desktop of LXDE needs to be started before the wallpaper can be set.
+	if f.Config.Setter == "pcmanfm" {
 		err := f.writeAutostart()
 		if err != nil {
 			return err
 		}
-	case "gnome":
-		script = setScriptGNOME
-	default:
-		return fmt.Errorf("Unknown configuration type: %s\n", cfg)
 	}
-	if err := writeWallpaperScript(script); err != nil {
-		return err
+	if f.Config.Setter == scriptSetterName {
+		present, err := exists(wallpaperSetScript())
+		if err != nil {
+			return err
+		}
+		if !present {
+			if err := writeWallpaperScript(setScriptTemplate); err != nil {
+				return err
+			}
+		}
 	}
 	return f.Loadconfig()
 }
 
 // Configure initializes the configuration according the config argument and does seeding
-// of images.
-func (f *Frontend) Configure(cfg string) error {
-	err := f.configure(cfg)
+// of images. Like Seed, it holds the lock only while it writes the configuration
+// and sets the wallpaper.
+func (f *Frontend) Configure(ctx context.Context, cfg string) error {
+	err := withLock(func() error { return f.configure(cfg) })
 	if err != nil {
 		return err
 	}
-	return f.Seed()
+	return f.Seed(ctx)
 }
 
 // Loadconfig loads APOD config from disk or, failing that, returns an error.
@@ -326,12 +409,37 @@ func (f *Frontend) Loadconfig() error {
 	}
 	f.storage.Config = f.Config
 	f.loader.Config = f.Config
-	return err
+	f.loader.Sources, err = f.sources()
+	if err != nil {
+		return err
+	}
+	return f.storage.migrate()
+}
+
+// sources constructs the sources named in the configuration.
+func (f *Frontend) sources() ([]Source, error) {
+	names := f.Config.Sources
+	if len(names) == 0 {
+		names = []string{apodName}
+	}
+	var sources []Source
+	for _, name := range names {
+		if name == apodName {
+			sources = append(sources, f.APOD)
+			continue
+		}
+		factory, ok := sourceFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown source in configuration: %s", name)
+		}
+		sources = append(sources, factory(f.APOD.Fetcher, f.loader.Clock))
+	}
+	return sources, nil
 }
 
 // OpenAPOD opens the web page at apod.nasa.gov for APOD given day in the default browser.
 func (f *Frontend) OpenAPOD(isodate ADate) error {
-	url := f.APOD.UrlForDate(ADate(isodate))
+	url := f.APOD.UrlForDate(isodate)
 	return open.Start(url)
 }
 
@@ -340,58 +448,201 @@ func (f *Frontend) OpenAPODToday() error {
 	return f.OpenAPOD(f.Today())
 }
 
-// OpenAPODOnBackground opens the apod.nasa.gov page for the wallpaper now showing, or failing that, throws an error.
-func (f *Frontend) OpenAPODOnBackground() error {
+// OpenPage opens the web page describing the wallpaper in the default browser.
+func (f *Frontend) OpenPage(w Wallpaper) error {
+	return open.Start(f.pageURL(w))
+}
+
+// pageURL returns the video the wallpaper is a still of, or the page
+// recorded in its metadata, or failing that, the page its source has for the
+// date.
+func (f *Frontend) pageURL(w Wallpaper) string {
+	if e, err := f.storage.Entry(w); err == nil {
+		if e.VideoStill && e.Video != "" {
+			return videoPage(e.Video)
+		}
+		if e.URL != "" {
+			return e.URL
+		}
+	}
+	if src, err := source(f.loader.Sources, w.Source); err == nil {
+		return src.PageURL(w.Date)
+	}
+	if factory, ok := sourceFactories[w.Source]; ok {
+		return factory(f.APOD.Fetcher, f.loader.Clock).PageURL(w.Date)
+	}
+	return f.APOD.UrlForDate(w.Date)
+}
+
+// OpenAPODOnBackground opens the page for the wallpaper now showing on
+// output, or on any output if it is empty, or failing that, throws an error.
+func (f *Frontend) OpenAPODOnBackground(output string) error {
 	s, err := f.State()
 	if err != nil {
 		return fmt.Errorf("Could not get hold on the picture that is currently shown, because: %v", err)
 	}
-	return f.OpenAPOD(s.DateCode)
+	if output != "" {
+		if s, err = s.output(output); err != nil {
+			return err
+		}
+	}
+	return f.OpenPage(s.Wallpaper())
+}
+
+// output returns the state of the named output.
+func (s State) output(name string) (State, error) {
+	o, ok := s.Outputs[name]
+	if !ok {
+		return State{}, fmt.Errorf("Unknown output: %s", name)
+	}
+	return o, nil
 }
 
 // Jump jumps to an image n places further or back if n is negative in the wallpaper directory.
 func (f *Frontend) Jump(n int) error {
-	all, err := f.storage.DownloadedWallpapers()
+	return f.JumpOutput("", n)
+}
+
+// JumpOutput jumps n places on the named output only, or on all outputs if
+// output is empty.
+func (f *Frontend) JumpOutput(output string, n int) error {
+	s, err := f.State()
 	if err != nil {
 		return err
 	}
-	var idx int
-	s, err := f.State()
+	if output == "" {
+		return f.setWallpaper(func(screen image.Point) (State, error) {
+			return f.jumpFrom(s, n, screen)
+		}, true)
+	}
+	o, err := s.output(output)
 	if err != nil {
 		return err
 	}
-	idx, err = f.storage.IndexOf(s.DateCode)
+	// the history records what the output shows, not what the others show
+	err = f.setWallpaper(func(screen image.Point) (State, error) {
+		o, err = f.jumpFrom(o, n, screen)
+		if err != nil {
+			return State{}, err
+		}
+		outputs := make(map[string]State)
+		for name, st := range s.Outputs {
+			outputs[name] = st
+		}
+		outputs[output] = o
+		s.Outputs = outputs
+		return s, nil
+	}, false)
 	if err != nil {
 		return err
 	}
+	f.record(o.Wallpaper())
+	return nil
+}
+
+// jumpFrom returns the state showing the wallpaper n places from the one of s,
+// passing over banned wallpapers, with the options for it on a screen of
+// size screen.
+func (f *Frontend) jumpFrom(s State, n int, screen image.Point) (State, error) {
+	all, err := f.eligible()
+	if err != nil {
+		return State{}, err
+	}
+	w := s.Wallpaper()
+	idx := sort.Search(len(all), func(i int) bool { return !all[i].before(w) })
+	if idx == len(all) || all[idx] != w {
+		if present, _ := f.Config.IsDownloaded(w); !present {
+			return State{}, fmt.Errorf("%s was not found", w)
+		}
+		// w is banned, one step forward lands on the wallpaper after it
+		if n > 0 {
+			idx--
+		}
+	}
 	toGo := idx + n
 	if toGo >= len(all) {
-		return fmt.Errorf("End reached")
+		return State{}, errEndReached
 	}
 	if toGo < 0 {
-		return fmt.Errorf("Begin reached")
+		return State{}, errBeginReached
 	}
-	code := all[toGo]
-	st := State{DateCode: code, Options: fit}
-	return f.SetWallpaper(st)
+	return newState(all[toGo], f.optionsFor(all[toGo], s.Options, screen)), nil
 }
 
 // SetWallpaper sets the wallpaper to the image from the wallpaper directory for the given date.
+// If the monitors show different images, outputs without an image of their
+// own in s get one. The wallpaper is recorded in the history.
 func (f *Frontend) SetWallpaper(s State) error {
-	wallpaper := f.Config.fileName(s.DateCode)
-	cmd := exec.Command(wallpaperSetScript())
-	env := os.Environ()
-	env = append(env, "WALLPAPER="+wallpaper)
-	env = append(env, "WALLPAPER_OPTIONS="+s.Options)
-	cmd.Env = env
-	output, err := cmd.CombinedOutput()
+	return f.setWallpaper(func(image.Point) (State, error) { return s, nil }, true)
+}
+
+// show sets the wallpaper to w, with the options for it.
+func (f *Frontend) show(w Wallpaper) error {
+	return f.setWallpaper(func(screen image.Point) (State, error) { return f.stateFor(w, screen), nil }, true)
+}
+
+// setWallpaper sets the wallpaper to the state choose returns for the size
+// of the screen, recording it in the history if record is set. The monitors
+// are listed once, for both choosing and setting the wallpaper.
+func (f *Frontend) setWallpaper(choose func(screen image.Point) (State, error), record bool) error {
+	monitors, err := f.monitorsFor()
+	if err != nil {
+		if f.Config.Monitors == monitorsDifferent {
+			return err
+		}
+		f.Log.Printf("Could not find the size of the screen, because: %v\n", err)
+	}
+	s, err := choose(f.modeScreen(monitors))
+	if err != nil {
+		return err
+	}
+	if err := f.apply(s, monitors); err != nil {
+		return err
+	}
+	if record {
+		f.record(s.Wallpaper())
+	}
+	return nil
+}
+
+// apply sets the wallpaper like SetWallpaper on monitors, without recording it.
+func (f *Frontend) apply(s State, monitors []Monitor) error {
+	setter, err := newSetter(f.Config.Setter, f.run)
+	if err != nil {
+		return err
+	}
+	switch f.Config.Monitors {
+	case "", monitorsSame:
+		s.Outputs = nil
+		err = setter.Set(f.screenFile(s.Wallpaper(), f.screenSize(monitors)), s.Options)
+	case monitorsSpan:
+		s.Outputs = nil
+		spanner, ok := setter.(Spanner)
+		if !ok {
+			return fmt.Errorf("The %s setter can not span an image over the monitors", f.Config.Setter)
+		}
+		err = spanner.Span(f.screenFile(s.Wallpaper(), f.screenSize(monitors)), s.Options)
+	case monitorsDifferent:
+		s, err = f.setOutputs(setter, s, monitors)
+	default:
+		return fmt.Errorf("Unknown monitors mode: %s", f.Config.Monitors)
+	}
+	if err != nil {
+		return err
+	}
+	err = store(s)
 	if err != nil {
-		return fmt.Errorf("Error running Wallpaper-Set-Script: %v. Output: %s", err, string(output))
+		return err
+	}
+	if f.keep {
+		f.current = &s
+		f.currentRead, _ = os.Stat(stateFile())
 	}
-	return store(s)
+	return nil
 }
 
-// ToggleViewMode toggles the view mode fill/zoom. It returns the new state.
+// ToggleViewMode toggles the view mode fill/zoom, and remembers the choice
+// for the images shown. It returns the new state.
 func (f *Frontend) ToggleViewMode() (string, error) {
 	s, err := f.State()
 	if err != nil {
@@ -402,9 +653,57 @@ func (f *Frontend) ToggleViewMode() (string, error) {
 	} else {
 		s.Options = fit
 	}
+	shown := []Wallpaper{s.Wallpaper()}
+	outputs := make(map[string]State)
+	for name, o := range s.Outputs {
+		o.Options = s.Options
+		outputs[name] = o
+		shown = append(shown, o.Wallpaper())
+	}
+	s.Outputs = outputs
+	if err := storeOverride(s.Options, shown...); err != nil {
+		return "", err
+	}
 	return s.Options, f.SetWallpaper(s)
 }
 
+// Status describes the wallpaper being shown and its options.
+func (f *Frontend) Status() (string, error) {
+	s, err := f.State()
+	if err != nil {
+		return "", err
+	}
+	status := fmt.Sprintf("%s %s", s.Wallpaper(), s.Options)
+	var names []string
+	for name := range s.Outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o := s.Outputs[name]
+		status += fmt.Sprintf("\n%s: %s %s", name, o.Wallpaper(), o.Options)
+	}
+	return status, nil
+}
+
+// displayPrevious displays the current wallpaper, or a random one if its
+// image is missing, so that the desktop does not wait for the download.
+func (f *Frontend) displayPrevious() {
+	s, err := f.State()
+	if err != nil {
+		f.Log.Printf("Could not read the state, because: %v\n", err)
+		return
+	}
+	if present, _ := f.Config.IsDownloaded(s.Wallpaper()); present {
+		err = f.SetWallpaper(s)
+	} else {
+		err = f.RandomArchive()
+	}
+	if err != nil {
+		f.Log.Printf("Could not display a wallpaper while downloading, because: %v\n", err)
+	}
+}
+
 // DisplayCurrent reads the State file and sets the wallpaper accordingly.
 func (f *Frontend) DisplayCurrent() error {
 	isodate, err := f.State()
@@ -415,16 +714,16 @@ func (f *Frontend) DisplayCurrent() error {
 	return err
 }
 
-func initLogging() (*log.Logger, *os.File, error) {
+func initLogging(path string) (*log.Logger, *os.File, error) {
 	var logger *log.Logger
 	err := MakeConfigDir()
 	if err != nil {
 		return nil, nil, fmt.Errorf("Could not create config dir")
 	}
-	f, err := os.OpenFile(logFile(), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
+	f, err := os.OpenFile(logFile(path), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0660)
 
 	if err != nil {
-		return nil, f, fmt.Errorf("Could not open logfile %q, because: %v\n", logFile(), err)
+		return nil, f, fmt.Errorf("Could not open logfile %q, because: %v\n", logFile(path), err)
 	}
 	mw := io.MultiWriter(os.Stdout, f)
 
@@ -433,189 +732,108 @@ func initLogging() (*log.Logger, *os.File, error) {
 }
 
 // RunAtLogin should be configured to run when the user starts her
-// windowmanager. It checks for a new APOD image. If there is a new
+// windowmanager. It displays the previous wallpaper at once and then checks
+// for a new APOD image, for at most LoginTimeout. If there is a new
 // image is sets this as background, otherwise it display a random
-// archive image.
-func (f *Frontend) RunAtLogin() error {
+// archive image. It holds the lock only while it sets the wallpaper, not
+// during the download, so it must be called without holding it.
+func (f *Frontend) RunAtLogin(ctx context.Context) error {
+	_, err := f.login(ctx)
+	return err
+}
+
+// login runs the login procedure. Besides the error that stopped it, it
+// returns the error of downloading today's image, which does not stop it.
+func (f *Frontend) login(ctx context.Context) (loadErr error, err error) {
 	today := f.Today()
-	if downloaded, err := f.Config.IsDownloaded(today); downloaded || err != nil {
+	if downloaded, err := f.downloadedOn(today); downloaded || err != nil {
 		if err != nil {
-			return fmt.Errorf("Could not check whether today was downloaded, because: %v\n", err)
+			return nil, fmt.Errorf("Could not check whether today was downloaded, because: %v\n", err)
 		}
-		err := f.DisplayCurrent()
+		err := withLock(f.DisplayCurrent)
 		if err != nil {
-			return fmt.Errorf("Today was already downloaded, but could not display the current wallpaper, because: %v\n", err)
+			return nil, fmt.Errorf("Today was already downloaded, but could not display the current wallpaper, because: %v\n", err)
 		} else {
 			f.Log.Printf("Displayed the current wallpaper, as today was already downloaded\n")
 		}
-		return nil
+		return nil, nil
 	}
-	ok, err := f.loader.Download(today)
-	if err != nil {
-		f.Log.Printf("An error occurred during todays (%s) image downloading: %v\n", today, err)
+	withLock(func() error {
+		f.displayPrevious()
+		return nil
+	})
+	ctx, cancel := context.WithTimeout(ctx, f.Options.LoginTimeout)
+	defer cancel()
+	loaded, loadErr := f.loader.DownloadDay(ctx, today)
+	if loadErr != nil {
+		f.Log.Printf("An error occurred during todays (%s) image downloading: %v\n", today, loadErr)
 		// The show must go on
 	}
-	if !ok {
+	if len(loaded) == 0 {
 		f.Log.Printf("No new image today (%s) on APOD\n", today)
 
 		f.Notify(fmt.Sprintf("No new image today :-("))
-		err := f.RandomArchive()
+		err := withLock(f.RandomArchive)
 		if err != nil {
-			return fmt.Errorf("Could not display a random archive, because: %v\n", err)
+			return loadErr, fmt.Errorf("Could not display a random archive, because: %v\n", err)
 		} else {
 			f.Log.Printf("Displayed a random archive wallpaper, as today had no new image\n")
 		}
-		return nil
+		return loadErr, nil
 	}
-	err = f.SetWallpaper(State{DateCode: today, Options: "fit"})
+	err = withLock(func() error { return f.show(loaded[0]) })
 	if err != nil {
-		return fmt.Errorf("Could not set the wallpaper to %s, because: %v\n", today, err)
+		return loadErr, fmt.Errorf("Could not set the wallpaper to %s, because: %v\n", today, err)
 	} else {
 		mesg := fmt.Sprintf("Wallpaper set to %s\n", today)
 		f.Notify(mesg)
 		f.Log.Printf(mesg)
 	}
-	return nil
+	return loadErr, nil
 }
 
-// RandomArchive picks a random image from the already downloaded
-// images
+// downloadedOn checks whether any of the sources has an image downloaded for the date.
+func (f *Frontend) downloadedOn(date ADate) (bool, error) {
+	for _, src := range f.loader.Sources {
+		downloaded, err := f.Config.IsDownloaded(Wallpaper{Source: src.Name(), Date: date})
+		if downloaded || err != nil {
+			return downloaded, err
+		}
+	}
+	return false, nil
+}
+
+// RandomArchive draws a random image from the already downloaded images, each
+// once before any is shown again, favorites more often than others and banned
+// images never.
 func (f *Frontend) RandomArchive() error {
-	rand.Seed(time.Now().Unix())
-	bs, err := f.storage.DownloadedWallpapers()
+	bs, err := f.eligible()
 	if err != nil {
 		return err
 	}
-	n := len(bs)
-	if n == 0 {
+	if len(bs) == 0 {
 		return fmt.Errorf("No backgrounds downloaded yet")
 	}
-	if n > 1 {
-		// Don't want yesterdays wallpaper
-		n -= 1
+	var last Wallpaper
+	if s, err := f.State(); err == nil {
+		last = s.Wallpaper()
 	}
-	pick := bs[rand.Intn(n)]
-	s, err := f.State()
+	w, err := drawFromBag(bs, last)
 	if err != nil {
 		return err
 	}
-	s.DateCode = pick
-	return f.SetWallpaper(s)
+	return f.show(w)
 }
 
-// Execute is the entry point for the apod-bg command
-func Execute() error {
-	logger, f, err := initLogging()
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-	defer f.Close()
-	var front *Frontend
-	logger.Printf("apod-bg starts")
-	if *nonotify {
-		front = NewFrontend(logger, Notifier{gnotifier.NullNotification})
-	} else {
-		front = NewFrontend(logger, Notifier{gnotifier.Notification})
-	}
-	if *configFlag != "" {
-		err := front.Configure(*configFlag)
-		if err != nil {
-			err = fmt.Errorf("Could not properly configure the apod-bg, because: %v\n", err)
-			logger.Printf("%v\n", err)
-			return err
-		}
-		logger.Printf("apod-bg was successfully configured\n")
-		return nil
-	}
-	err = front.Loadconfig()
+// Show sets the wallpaper to w, downloading its image if needed. Like
+// RunAtLogin, it takes the lock only to set the wallpaper.
+func (f *Frontend) Show(ctx context.Context, w Wallpaper) error {
+	loaded, err := f.loader.Download(ctx, w)
 	if err != nil {
-		err = fmt.Errorf("Could not load the configuration, because: %v\n", err)
-		logger.Printf("%v\n", err)
 		return err
 	}
-
-	if *apodFlag {
-		err := front.OpenAPODToday()
-		if err != nil {
-			err = fmt.Errorf("Could not open the APOD page, because: %v\n", err)
-			logger.Printf("%v\n", err)
-			return err
-		} else {
-			mesg := "Opened the default browser on APOD\n"
-			front.Notify(mesg)
-			logger.Printf(mesg)
-		}
-		return nil
-	}
-
-	if *unconfigFlag {
-		err := front.removeAutostart()
-		if err != nil {
-			logger.Printf("%v\n", err)
-			return err
-		}
-	}
-
-	if *randomFlag {
-		err := front.RandomArchive()
-		if err != nil {
-			logger.Printf("%v\n", err)
-			return err
-		}
+	if !loaded {
+		return fmt.Errorf("There is no image for %s", w)
 	}
-
-	if *info {
-		err := front.OpenAPODOnBackground()
-		if err != nil {
-			err = fmt.Errorf("Could not open the APOD page on background now showing, because: %v\n", err)
-			logger.Printf("%v\n", err)
-			return err
-		}
-		logger.Printf("Opened the default browser on the APOD-page related to the current background image\n")
-		front.Notify("Browser opened on NASA apod-page belonging to this background")
-		return nil
-	}
-
-	if *login {
-		err := front.RunAtLogin()
-		if err != nil {
-			logger.Printf("%v\n", err)
-			return err
-		}
-	}
-
-	if *days > 0 {
-		err := front.loader.LoadPeriod(front.Today(), *days)
-		if err != nil {
-			logger.Printf("Error during fetch: %v\n", err)
-			return err
-		}
-	}
-
-	if *jump != 0 {
-		err := front.Jump(*jump)
-		if err != nil {
-			front.Notify(err.Error())
-			err = fmt.Errorf("Could not jump(%d): %v\n", *jump, err)
-			logger.Printf("%v\n", err)
-			return err
-		}
-		logger.Printf("Jump was successfull\n")
-		return nil
-	}
-
-	if *mode {
-		m, err := front.ToggleViewMode()
-		if err != nil {
-			err = fmt.Errorf("Could not toggle viewing options: %v\n", err)
-			logger.Printf("%v\n", err)
-			return err
-
-		} else {
-			logger.Printf("Inversed the viewing option to: %s\n", m)
-		}
-		return nil
-	}
-	return nil
+	return withLock(func() error { return f.show(w) })
 }
@@ -1,11 +1,13 @@
 package apod
 
 import (
+	"context"
 	"fmt"
 	"github.com/haklop/gnotifier"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
 	"math/rand"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -27,34 +29,9 @@ echo Fault 1>&2
 exit 5
 `
 
-func unsetNoseedFlag() {
-	falseB := false
-	noseed = &falseB
-}
-
-func setDateFlag(date string) {
-	dateFlag = &date
-	return
-}
-
-func resetFlags() {
-
-	zero := 0
-	trueB := true
-	falseB := false
-	emptyS := ""
-
-	info = &falseB
-	login = &falseB
-	logFileFlag = &emptyS
-	days = &zero
-	jump = &zero
-	configFlag = &emptyS
-	apodFlag = &falseB
-	mode = &falseB
-	nonotify = &trueB
-	noseed = &trueB
-	randomFlag = &falseB
+// setToday sets the clock of f to date.
+func setToday(f *Frontend, date string) {
+	f.Options.Clock = ClockAt(adate(date))
 }
 
 type nullLogger struct{}
@@ -63,7 +40,6 @@ func (n nullLogger) Printf(f string, i ...interface{}) {
 }
 
 func setupTestHome(t testing.TB) string {
-	resetFlags()
 	wd, err := os.Getwd()
 	assert.NoError(t, err)
 	testHome := filepath.Join(wd, fmt.Sprintf("test-home-%d", rand.Int63()))
@@ -71,6 +47,7 @@ func setupTestHome(t testing.TB) string {
 		t.Fatal(err)
 	}
 	os.Setenv("HOME", testHome)
+	os.Setenv("XDG_RUNTIME_DIR", testHome)
 	t.Logf("%s CREATED", testHome)
 	return testHome
 }
@@ -82,22 +59,23 @@ func cleanUp(t testing.TB, dir string) {
 }
 
 func makeStateFile(t testing.TB, datecode, options string) {
-	s := State{DateCode: ADate(datecode), Options: options}
+	s := State{DateCode: adate(datecode), Options: options}
 	err := store(s)
 	assert.NoError(t, err)
 }
 
 func frontendForTest(t *testing.T) (*Frontend, string) {
 	recorder := gnotifier.NewTestRecorder()
-	f := NewFrontend(nullLogger{}, Notifier{recorder.Notification})
-	setDateFlag("140921")
+	f := NewFrontend(nullLogger{}, Notifier{recorder.Notification}, Options{Clock: ClockAt(adate(testDateSeptember)), NoSeed: true})
 	f.APOD.Site = testAPODSite
+	f.loader.Thumbnails.YouTube = testAPODSite + "vi/%s/"
+	f.loader.Thumbnails.Vimeo = testAPODSite + "vimeo/oembed.json"
 	return f, setupTestHome(t)
 }
 
 func frontendForTestConfigured(t *testing.T) (*Frontend, string) {
 	f, testHome := frontendForTest(t)
-	err := f.Configure("barewm")
+	err := f.Configure(context.Background(), scriptSetterName)
 	assert.NoError(t, err)
 	assert.NoError(t, f.Loadconfig())
 	assert.NoError(t, writeWallpaperScript(setScriptSuccess))
@@ -107,19 +85,19 @@ func frontendForTestConfigured(t *testing.T) (*Frontend, string) {
 func TestSeed(t *testing.T) {
 	f, testHome := frontendForTestConfigured(t)
 	defer cleanUp(t, testHome)
-	unsetNoseedFlag()
-	assert.NoError(t, f.Seed())
+	f.Options.NoSeed = false
+	assert.NoError(t, f.Seed(context.Background()))
 }
 
 func TestSeedYoutube(t *testing.T) {
 	f, testHome := frontendForTestConfigured(t)
 	defer cleanUp(t, testHome)
-	setDateFlag(testDateYoutube)
-	unsetNoseedFlag()
-	assert.NoError(t, f.Seed())
+	setToday(f, testDateYoutube)
+	f.Options.NoSeed = false
+	assert.NoError(t, f.Seed(context.Background()))
 	s, err := f.State()
 	assert.NoError(t, err)
-	assert.Equal(t, "140921", s.DateCode.String())
+	assert.Equal(t, "140921", s.DateCode.Code())
 }
 
 func TestWriteAutostart(t *testing.T) {
@@ -165,26 +143,78 @@ func TestState(t *testing.T) {
 	makeStateFile(t, "140121", "fit")
 	rv, err := APOD.State()
 	assert.NoError(t, err, "Error during call to State")
-	assert.Equal(t, testDateString, rv.DateCode)
+	assert.Equal(t, adate(testDateString), rv.DateCode)
+}
+
+func TestStateCorrupt(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, f.Config, "140120", "140121")
+	for _, content := range []string{"", `{"DateCode":"2014-01`, "{}"} {
+		assert.NoError(t, ioutil.WriteFile(stateFile(), []byte(content), 0644))
+		s, err := f.State()
+		assert.NoError(t, err, content)
+		assert.Equal(t, State{DateCode: adate("140121"), Source: apodName, Options: fit}, s, content)
+	}
+	assert.NoError(t, f.Jump(-1), "Jump should work again")
+	s, err := f.State()
+	assert.NoError(t, err)
+	assert.Equal(t, adate("140120"), s.DateCode)
+}
+
+func TestStoreLeavesNoTemporaryFiles(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeStateFile(t, "140121", fit)
+	makeStateFile(t, "140120", zoom)
+	s, err := f.State()
+	assert.NoError(t, err)
+	assert.Equal(t, State{DateCode: adate("140120"), Options: zoom}, s)
+	names, err := filepath.Glob(filepath.Join(configDir(), ".*"))
+	assert.NoError(t, err)
+	assert.Empty(t, names)
 }
 
 func RunConfiguration(t *testing.T, cfg string, expected string) {
 	f, testHome := frontendForTest(t)
 	defer cleanUp(t, testHome)
-	f.Configure(cfg)
-	script := wallpaperSetScript()
-	bs, err := ioutil.ReadFile(script)
+	assert.NoError(t, f.Configure(context.Background(), cfg))
+	assert.Equal(t, expected, f.Config.Setter)
+	being, err := exists(autostartFile())
 	assert.NoError(t, err)
-	assert.Equal(t, expected, string(bs))
+	assert.Equal(t, expected == "pcmanfm", being, "the autostart entry is written for pcmanfm only: %s", cfg)
 }
 
 func TestConfiguration(t *testing.T) {
-	for _, cfg := range [][]string{[]string{"barewm", setScriptBareWM},
-		[]string{"gnome", setScriptGNOME}, []string{"lxde", setScriptLXDE}} {
+	for _, cfg := range [][]string{[]string{"barewm", "feh"},
+		[]string{"gnome", "gsettings"}, []string{"lxde", "pcmanfm"},
+		[]string{"pcmanfm", "pcmanfm"}, []string{"sway", "sway"}, []string{"script", "script"}} {
 		RunConfiguration(t, cfg[0], cfg[1])
 	}
 }
 
+func TestConfigurationUnknown(t *testing.T) {
+	f, testHome := frontendForTest(t)
+	defer cleanUp(t, testHome)
+	err := f.Configure(context.Background(), "windows")
+	assert.Equal(t, "Unknown configuration type: windows\n", err.Error())
+}
+
+func TestConfigurationScript(t *testing.T) {
+	f, testHome := frontendForTest(t)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.Configure(context.Background(), scriptSetterName))
+	bs, err := ioutil.ReadFile(wallpaperSetScript())
+	assert.NoError(t, err)
+	assert.Equal(t, setScriptTemplate, string(bs))
+
+	assert.NoError(t, writeWallpaperScript(setScriptSuccess))
+	assert.NoError(t, f.Configure(context.Background(), scriptSetterName))
+	bs, err = ioutil.ReadFile(wallpaperSetScript())
+	assert.NoError(t, err)
+	assert.Equal(t, setScriptSuccess, string(bs), "An existing script should be kept")
+}
+
 func TestLoadconfigNonExistent(t *testing.T) {
 	f, testHome := frontendForTest(t)
 	defer cleanUp(t, testHome)
@@ -204,11 +234,10 @@ func TestLoadconfigExistent(t *testing.T) {
 }
 
 func TestToday(t *testing.T) {
-	front := Frontend{}
-	setDateFlag(testDateString)
+	front := NewFrontend(nullLogger{}, Notifier{}, Options{Clock: ClockAt(adate(testDateString))})
 	var ad ADate
 	ad = front.Today()
-	assert.Equal(t, ad.String(), testDateString)
+	assert.Equal(t, ad.Code(), testDateString)
 }
 
 func TestRandomArchiveEmpty(t *testing.T) {
@@ -250,7 +279,7 @@ func TestSetWallpaperSuccess(t *testing.T) {
 	front, testHome := frontendForTestConfigured(t)
 	defer cleanUp(t, testHome)
 	writeWallpaperScript(setScriptSuccess)
-	err := front.SetWallpaper(State{DateCode: testDateString})
+	err := front.SetWallpaper(State{DateCode: adate(testDateString)})
 	assert.NoError(t, err)
 }
 
@@ -258,18 +287,14 @@ func TestSetWallpaperFailure(t *testing.T) {
 	front, testHome := frontendForTestConfigured(t)
 	defer cleanUp(t, testHome)
 	writeWallpaperScript(setScriptFailure)
-	err := front.SetWallpaper(State{DateCode: testDateString})
+	err := front.SetWallpaper(State{DateCode: adate(testDateString)})
 	assert.Equal(t, "Error running Wallpaper-Set-Script: exit status 5. Output: Something went wrong\nFault\n", err.Error())
 }
 
 func TestConfigurationE2e(t *testing.T) {
 	testHome := setupTestHome(t)
 	defer cleanUp(t, testHome)
-	resetFlags()
-
-	cfg := "barewm"
-	configFlag = &cfg
-	assert.NoError(t, Execute())
+	assert.NoError(t, Execute([]string{"-nonotify", "-noseed", "-config=barewm"}))
 }
 
 func TestJumpWithStateE2e(t *testing.T) {
@@ -278,9 +303,48 @@ func TestJumpWithStateE2e(t *testing.T) {
 	makeStateFile(t, "140120", "fit")
 	makeTestWallpapers(t, f.Config, "140119", "140120")
 	writeWallpaperScript(setScriptSuccess)
-	resetFlags()
+	assert.NoError(t, Execute([]string{"-nonotify", "-jump=-1"}))
+}
+
+func TestCombinedFlagsE2e(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeStateFile(t, "140120", fit)
+	makeTestWallpapers(t, f.Config, "140119", "140120")
+	assert.NoError(t, Execute([]string{"-nonotify", "-mode", "-random"}))
+	s, err := f.State()
+	assert.NoError(t, err)
+	assert.Equal(t, zoom, s.Options, "random runs first, then mode")
+}
+
+func TestFutureDateFlagE2e(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, f.Config, "140119", "140120")
+	date := NewADate(time.Now().AddDate(0, 0, 2)).String()
+	assert.Contains(t, Execute([]string{"-nonotify", "-date=" + date}).Error(), "lies in the future")
+}
+
+func TestRunAtLoginDisplaysPreviousAndTimesOut(t *testing.T) {
+	s := &stallingServer{content: []byte("<html>"), stalls: 1}
+	server := httptest.NewServer(s)
+	defer server.Close()
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	f.APOD.Site = server.URL + "/"
+	f.Options.LoginTimeout = 50 * time.Millisecond
+	makeTestWallpapers(t, f.Config, "140120")
+	makeStateFile(t, "140120", zoom)
+	start := time.Now()
+	assert.NoError(t, f.RunAtLogin(context.Background()))
+	assert.True(t, time.Since(start) < time.Second, "The download should time out")
+	st, err := f.State()
+	assert.NoError(t, err)
+	assert.Equal(t, adate("140120"), st.DateCode)
+}
 
-	j := -1
-	jump = &j
-	assert.NoError(t, Execute())
+func TestValidateDateClock(t *testing.T) {
+	clock := ClockAt(adate("140110"))
+	assert.NoError(t, validateDate("2014-01-10", clock))
+	assert.Error(t, validateDate("2014-01-11", clock))
 }
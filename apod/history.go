@@ -0,0 +1,151 @@
+package apod
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// historyLimit is the number of wallpapers the history remembers.
+const historyLimit = 100
+
+const historyBasename = "history.json"
+
+func historyFile() string {
+	return filepath.Join(configDir(), historyBasename)
+}
+
+// visit is a wallpaper shown, and when it was first shown.
+type visit struct {
+	Wallpaper Wallpaper
+	Shown     time.Time
+}
+
+// history holds the wallpapers shown, oldest first, and the position of the
+// one shown now, which back and forward move like in a browser.
+type history struct {
+	Visits  []visit
+	Current int
+}
+
+// readHistory reads the history. A corrupt history file, like a corrupt state
+// file, is not fatal: the history starts over.
+func (f *Frontend) readHistory() (*history, error) {
+	h := new(history)
+	bs, err := ioutil.ReadFile(historyFile())
+	if os.IsNotExist(err) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(bs, h)
+	if err == nil && h.Current != 0 && (h.Current < 0 || h.Current >= len(h.Visits)) {
+		err = fmt.Errorf("current visit %d out of range", h.Current)
+	}
+	if err != nil {
+		f.Log.Printf("The history file %s is corrupt (%v), starting over\n", historyFile(), err)
+		return new(history), nil
+	}
+	return h, nil
+}
+
+func (h *history) store() error {
+	bs, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(historyFile(), bs, 0644)
+}
+
+// add records w as shown at t, dropping the visits after the current one and
+// the oldest beyond the limit. Showing the current visit again is no visit.
+func (h *history) add(w Wallpaper, t time.Time) {
+	if len(h.Visits) > 0 && h.Visits[h.Current].Wallpaper.String() == w.String() {
+		return
+	}
+	if len(h.Visits) > 0 {
+		h.Visits = h.Visits[:h.Current+1]
+	}
+	h.Visits = append(h.Visits, visit{Wallpaper: w, Shown: t})
+	if len(h.Visits) > historyLimit {
+		h.Visits = h.Visits[len(h.Visits)-historyLimit:]
+	}
+	h.Current = len(h.Visits) - 1
+}
+
+// record adds w to the history, failing to do so does not stop the show.
+func (f *Frontend) record(w Wallpaper) {
+	h, err := f.readHistory()
+	if err == nil {
+		h.add(w, f.Options.Clock.Now())
+		err = h.store()
+	}
+	if err != nil {
+		f.Log.Printf("Could not record %s in the history, because: %v\n", w, err)
+	}
+}
+
+// Back shows the wallpaper shown before the current one.
+func (f *Frontend) Back() error {
+	return f.browse(-1)
+}
+
+// Forward shows the wallpaper shown after the current one, after going back.
+func (f *Frontend) Forward() error {
+	return f.browse(1)
+}
+
+// browse moves through the history in the direction of step, passing the
+// wallpapers that are no longer downloaded.
+func (f *Frontend) browse(step int) error {
+	h, err := f.readHistory()
+	if err != nil {
+		return err
+	}
+	for i := h.Current + step; i >= 0 && i < len(h.Visits); i += step {
+		w := h.Visits[i].Wallpaper
+		if present, _ := f.Config.IsDownloaded(w); !present {
+			continue
+		}
+		err := f.setWallpaper(func(screen image.Point) (State, error) { return f.stateFor(w, screen), nil }, false)
+		if err != nil {
+			return err
+		}
+		h.Current = i
+		return h.store()
+	}
+	if step < 0 {
+		return errBeginReached
+	}
+	return errEndReached
+}
+
+// History lists the wallpapers shown, with when and their titles, the
+// current one marked with a star.
+func (f *Frontend) History() (string, error) {
+	h, err := f.readHistory()
+	if err != nil {
+		return "", err
+	}
+	if len(h.Visits) == 0 {
+		return "", fmt.Errorf("No history yet")
+	}
+	list := ""
+	for i, v := range h.Visits {
+		mark := " "
+		if i == h.Current {
+			mark = "*"
+		}
+		line := fmt.Sprintf("%s %s %s", mark, v.Shown.Format("2006-01-02 15:04"), v.Wallpaper)
+		if e, err := f.storage.Entry(v.Wallpaper); err == nil && e.Title != "" {
+			line += " " + e.Title
+		}
+		list += line + "\n"
+	}
+	return list, nil
+}
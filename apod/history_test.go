@@ -0,0 +1,89 @@
+package apod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistoryAdd(t *testing.T) {
+	h := new(history)
+	now := time.Now()
+	h.add(apodOn("140119"), now)
+	h.add(apodOn("140120"), now)
+	h.add(apodOn("140120"), now)
+	assert.Equal(t, 2, len(h.Visits), "showing the current one again is no visit")
+	h.Current = 0
+	h.add(apodOn("140121"), now)
+	assert.Equal(t, []visit{{apodOn("140119"), now}, {apodOn("140121"), now}}, h.Visits)
+	assert.Equal(t, 1, h.Current)
+
+	for i := 0; i < historyLimit; i++ {
+		h.add(apodOn(fmt.Sprintf("1402%02d", i%28+1)), now)
+	}
+	assert.Equal(t, historyLimit, len(h.Visits))
+	assert.Equal(t, historyLimit-1, h.Current)
+}
+
+func TestBackForward(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	assert.Equal(t, errBeginReached, f.Back())
+	assert.NoError(t, f.Jump(1))
+	assert.NoError(t, f.Jump(-1))
+	assert.NoError(t, f.Jump(-1))
+	assertShowing(t, f, "apod:2014-01-19 fit")
+
+	assert.NoError(t, f.Back())
+	assertShowing(t, f, "apod:2014-01-20 fit")
+	assert.NoError(t, f.Back())
+	assertShowing(t, f, "apod:2014-01-21 fit")
+	assert.Equal(t, errBeginReached, f.Back())
+	assert.NoError(t, f.Forward())
+	assertShowing(t, f, "apod:2014-01-20 fit")
+
+	assert.NoError(t, f.Jump(-1))
+	assert.Equal(t, errEndReached, f.Forward())
+	h, err := f.readHistory()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(h.Visits), "going somewhere else drops the visits ahead")
+	assert.NoError(t, f.Back())
+	assertShowing(t, f, "apod:2014-01-20 fit")
+}
+
+func TestHistoryList(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	_, err := f.History()
+	assert.Equal(t, "No history yet", err.Error())
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: apodOn("140121").Date, Title: "M8"}))
+	assert.NoError(t, f.Jump(1))
+	assert.NoError(t, f.Jump(-1))
+	list, err := f.History()
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(list, "\n"), "\n")
+	assert.Equal(t, 2, len(lines))
+	today := f.Options.Clock.Now().Format("2006-01-02")
+	assert.True(t, strings.HasPrefix(lines[0], "  "+today+" "), lines[0])
+	assert.True(t, strings.HasSuffix(lines[0], " apod:2014-01-21 M8"), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "* "), lines[1])
+	assert.True(t, strings.HasSuffix(lines[1], " apod:2014-01-20"), lines[1])
+}
+
+func TestHistoryCorrupt(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	for _, content := range []string{`{"Visits":[{"Wallpaper":"apod:2014-01-19"}],"Current":3}`, `{"Visits":[`} {
+		assert.NoError(t, ioutil.WriteFile(historyFile(), []byte(content), 0644))
+		assert.NoError(t, f.Jump(1), content)
+		h, err := f.readHistory()
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(h.Visits), content)
+		assert.Equal(t, 0, h.Current, content)
+		assert.NoError(t, f.Jump(-1))
+	}
+}
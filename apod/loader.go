@@ -1,54 +1,283 @@
 package apod
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 )
 
 type Loader struct {
-	APOD   *APOD
-	Config *config
+	// Sources are the sources images are loaded from.
+	Sources []Source
+	Config  *config
+	// Workers is the number of concurrent downloads in LoadPeriod.
+	Workers int
+	// Clock decides which dates lie in the future.
+	Clock Clock
+	// Thumbnails finds the stills shown for video days, they are skipped if nil.
+	Thumbnails *Thumbnails
 	Notifier
 	logger
+	// batch collects the entries to index, they are indexed one by one if nil.
+	batch *indexBatch
 }
 
-// Download downloads the image from apod.nasa.gov for the given date.
-func (l *Loader) Download(isodate ADate) (bool, error) {
-	if downloaded, _ := l.Config.IsDownloaded(isodate); downloaded {
-		return true, nil
+// Report summarizes the outcome of LoadPeriod.
+type Report struct {
+	Downloaded []Wallpaper
+	// Videos are the video days without a still.
+	Videos  []Wallpaper
+	Present []Wallpaper
+	// NoImage are the days without an image or a video.
+	NoImage []Wallpaper
+	Failed  map[Wallpaper]error
+}
+
+// add counts the outcome of downloading w, e is its entry if it was looked up.
+func (r *Report) add(w Wallpaper, present, loaded bool, e *Entry, err error) {
+	switch {
+	case err != nil:
+		r.Failed[w] = err
+	case present:
+		r.Present = append(r.Present, w)
+	case loaded:
+		r.Downloaded = append(r.Downloaded, w)
+	case e != nil && e.Video != "":
+		r.Videos = append(r.Videos, w)
+	default:
+		r.NoImage = append(r.NoImage, w)
+	}
+}
+
+func (r *Report) failed() []Wallpaper {
+	var ws []Wallpaper
+	for w := range r.Failed {
+		ws = append(ws, w)
+	}
+	sortWallpapers(ws)
+	return ws
+}
+
+func (r *Report) String() string {
+	s := fmt.Sprintf("downloaded: %d, skipped video: %d, no image: %d, already present: %d, failed: %d",
+		len(r.Downloaded), len(r.Videos), len(r.NoImage), len(r.Present), len(r.Failed))
+	for _, w := range r.failed() {
+		s += fmt.Sprintf("\n%s: %v", w, r.Failed[w])
+	}
+	return s
+}
+
+// Err returns an error listing the failed wallpapers, or nil if none failed.
+func (r *Report) Err() error {
+	if len(r.Failed) == 0 {
+		return nil
+	}
+	var ws []string
+	for _, w := range r.failed() {
+		ws = append(ws, w.String())
+	}
+	return fmt.Errorf("Downloading failed for: %s", strings.Join(ws, ", "))
+}
+
+// Download downloads the image of the wallpaper from its source,
+// together with its metadata.
+func (l *Loader) Download(ctx context.Context, w Wallpaper) (bool, error) {
+	loaded, _, err := l.download(ctx, w)
+	return loaded, err
+}
+
+// download is Download, it also returns the entry of w if it was looked up.
+func (l *Loader) download(ctx context.Context, w Wallpaper) (bool, *Entry, error) {
+	src, err := source(l.Sources, w.Source)
+	if err != nil {
+		return false, nil, err
+	}
+	if err := w.Date.Validate(NewADate(l.Clock.Now())); err != nil {
+		return false, nil, err
+	}
+	if downloaded, _ := l.Config.IsDownloaded(w); downloaded {
+		l.completeEntry(ctx, src, w)
+		return true, nil, nil
+	}
+	e, err := src.Entry(ctx, w.Date)
+	if err != nil {
+		return false, nil, err
+	}
+	if e.Video != "" {
+		loaded, err := l.downloadStill(ctx, src, e)
+		return loaded, e, err
+	}
+	// the inline image stands in when the high resolution one fails
+	var urls []string
+	if e.HiRes != "" {
+		urls = append(urls, e.HiRes)
+	}
+	if e.Image != "" && e.Image != e.HiRes {
+		urls = append(urls, e.Image)
+	}
+	if len(urls) == 0 {
+		return false, e, nil
+	}
+	l.Notify(fmt.Sprintf("Downloading %s-image for: %s", src.Name(), w.Date))
+	file, err := fetchImage(ctx, src, l.Config.fileName(w), urls)
+	if err != nil {
+		return true, e, err
+	}
+	l.recordSize(e, file)
+	err = l.storeEntry(e)
+	if err != nil {
+		return true, e, err
+	}
+	l.Printf("Successfully downloaded %s to %q\n", w, file)
+	return true, e, nil
+}
+
+// fetchImage downloads the first of urls that holds a valid image to file,
+// and returns the name it is stored under. The partial file left by a url
+// that failed is removed, so the next url does not resume on it.
+func fetchImage(ctx context.Context, src Source, file string, urls []string) (string, error) {
+	var err error
+	for _, u := range urls {
+		if err = src.Download(ctx, file, u); err == nil {
+			var valid string
+			if valid, err = validateImage(file); err == nil {
+				return valid, nil
+			}
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		os.Remove(file + partSuffix)
 	}
-	pageURL := l.APOD.UrlForDate(isodate)
-	imgURL, err := l.APOD.ContainsImage(pageURL)
+	return "", err
+}
+
+// downloadStill downloads a still of the video of e as its image. It reports
+// false, like for any video day, if no still could be had.
+func (l *Loader) downloadStill(ctx context.Context, src Source, e *Entry) (bool, error) {
+	if l.Thumbnails == nil {
+		return false, nil
+	}
+	w := e.Wallpaper()
+	urls, err := l.Thumbnails.URLs(ctx, e)
 	if err != nil {
-		return false, err
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		l.Printf("Could not find a still for the video of %s: %v\n", w, err)
+		return false, nil
 	}
-	if imgURL == "" {
+	if len(urls) == 0 {
+		l.Printf("Could not find a still for the video of %s\n", w)
 		return false, nil
 	}
-	l.Notify(fmt.Sprintf("Downloading APOD-image for: %s", isodate))
-	file := l.Config.fileName(isodate)
-	err = l.APOD.Download(file, imgURL)
+	file, err := fetchImage(ctx, src, l.Config.fileName(w), urls)
+	if err != nil && ctx.Err() != nil {
+		return true, ctx.Err()
+	}
 	if err != nil {
+		l.Printf("Could not download a still for the video of %s: %v\n", w, err)
+		return false, nil
+	}
+	e.VideoStill = true
+	l.recordSize(e, file)
+	if err := l.storeEntry(e); err != nil {
 		return true, err
 	}
-	l.Printf("Successfully downloaded %s to %q\n", isodate, file)
+	l.Printf("Successfully downloaded a still of the video of %s to %q\n", w, file)
 	return true, nil
 }
 
-// LoadPeriod loads images from apod.nasa.gov to the wallpaper directory, for a number of days back
-func (l *Loader) LoadPeriod(from ADate, days int) error {
-	for _, isodate := range l.days(from, days) {
-		_, err := l.Download(isodate)
-		if err != nil {
-			return err
+// recordSize records the size of the image in file with e.
+func (l *Loader) recordSize(e *Entry, file string) {
+	size, err := imageSize(file)
+	if err != nil {
+		l.Printf("Could not read the size of %s, because: %v\n", e.Wallpaper(), err)
+		return
+	}
+	e.Width, e.Height = size.X, size.Y
+}
+
+// DownloadDay downloads the images of all sources for the given date. It
+// returns the wallpapers of that date that are present now, whether new or
+// downloaded before, and the first error.
+func (l *Loader) DownloadDay(ctx context.Context, date ADate) ([]Wallpaper, error) {
+	var (
+		loaded   []Wallpaper
+		firstErr error
+	)
+	for _, src := range l.Sources {
+		w := Wallpaper{Source: src.Name(), Date: date}
+		ok, err := l.Download(ctx, w)
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
+		if ok && err == nil {
+			loaded = append(loaded, w)
+		}
+	}
+	return loaded, firstErr
+}
+
+// completeEntry fetches the metadata for images that were downloaded before
+// metadata was kept.
+func (l *Loader) completeEntry(ctx context.Context, src Source, w Wallpaper) {
+	if present, _ := exists(l.Config.metaFileName(w)); present {
+		return
+	}
+	e, err := src.Entry(ctx, w.Date)
+	if err == nil {
+		err = l.storeEntry(e)
+	}
+	if err != nil {
+		l.Printf("Could not store the metadata for %s: %v\n", w, err)
 	}
-	return nil
 }
 
-func (l *Loader) days(from ADate, days int) []ADate {
-	var dates []ADate
-	for i := 1; i < days+1; i++ {
-		dates = append(dates, NewADate(from.Date().AddDate(0, 0, -i)))
+// LoadPeriod loads images from all sources to the wallpaper directory, for a number of days back.
+// The days are loaded by Workers concurrent workers, failing days do not stop the others.
+// The returned error is the error of the report. When ctx is done, the days not
+// loaded yet fail with its error. The metadata of the images is indexed at
+// once, when all days are done.
+func (l *Loader) LoadPeriod(ctx context.Context, from ADate, days int) (*Report, error) {
+	l = l.batched()
+	defer l.flush()
+	workers := l.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	jobs := make(chan Wallpaper)
+	r := &Report{Failed: make(map[Wallpaper]error)}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for w := range jobs {
+				present, _ := l.Config.IsDownloaded(w)
+				loaded, e, err := l.download(ctx, w)
+				mu.Lock()
+				r.add(w, present, loaded, e, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	first := NewADate(from.Date().AddDate(0, 0, -days))
+	for _, src := range l.Sources {
+		for _, d := range src.Dates(first, from.Back()) {
+			jobs <- Wallpaper{Source: src.Name(), Date: d}
+		}
 	}
-	return dates
+	close(jobs)
+	wg.Wait()
+	sortWallpapers(r.Downloaded)
+	sortWallpapers(r.Videos)
+	sortWallpapers(r.Present)
+	sortWallpapers(r.NoImage)
+	return r, r.Err()
 }
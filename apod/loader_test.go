@@ -1,17 +1,23 @@
 package apod
 
 import (
+	"context"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestDownload(t *testing.T) {
 	a, testHome := frontendForTestConfigured(t)
 	defer cleanUp(t, testHome)
-	_, err := a.loader.Download(testDateSeptember)
+	_, err := a.loader.Download(context.Background(), apodOn(testDateSeptember))
 	assert.NoError(t, err)
-	image := a.Config.fileName(testDateSeptember)
+	image := a.Config.fileName(apodOn(testDateSeptember))
 	i, err := os.Open(image)
 	assert.NoError(t, err)
 	info, err := i.Stat()
@@ -22,10 +28,12 @@ func TestDownload(t *testing.T) {
 func TestLoadPeriod(t *testing.T) {
 	a, testHome := frontendForTestConfigured(t)
 	defer cleanUp(t, testHome)
-	err := a.loader.LoadPeriod(ADate("140925"), 5)
+	r, err := a.loader.LoadPeriod(context.Background(), adate("140925"), 5)
 	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140920"), apodOn("140921"), apodOn("140923"), apodOn("140924")}, r.Downloaded)
+	assert.Equal(t, []Wallpaper{apodOn("140922")}, r.Videos)
 	for _, dateS := range []string{"140924", "140923", "140921", "140920"} {
-		file := a.Config.fileName(ADate(dateS))
+		file := a.Config.fileName(apodOn(dateS))
 		present, err := exists(file)
 		if err != nil {
 			t.Fatal(err)
@@ -33,3 +41,118 @@ func TestLoadPeriod(t *testing.T) {
 		assert.True(t, present, "%s should exist", file)
 	}
 }
+
+func TestDownloadStoresEntry(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	_, err := a.loader.Download(context.Background(), apodOn(testDateSeptember))
+	assert.NoError(t, err)
+	assert.Equal(t, ".jpg", filepath.Ext(a.Config.fileName(apodOn(testDateSeptember))))
+	e, err := a.storage.Entry(apodOn(testDateSeptember))
+	assert.NoError(t, err)
+	assert.Equal(t, "The Lagoon Nebula in Stars Dust and Gas", e.Title)
+	assert.Equal(t, "Remus Chua (Celestial Portraits)", e.Credit)
+	assert.True(t, e.Copyright)
+	assert.Equal(t, 41, e.Width)
+	assert.Equal(t, 48, e.Height)
+}
+
+func TestDownloadCompletesEntry(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, a.Config, testDateSeptember)
+	loaded, err := a.loader.Download(context.Background(), apodOn(testDateSeptember))
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	e, err := a.storage.Entry(apodOn(testDateSeptember))
+	assert.NoError(t, err)
+	assert.Equal(t, adate(testDateSeptember), e.Date)
+}
+
+func TestDownloadVideoDay(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	loaded, err := a.loader.Download(context.Background(), apodOn(testDateYoutube))
+	assert.NoError(t, err)
+	assert.False(t, loaded)
+	present, err := exists(a.Config.metaFileName(apodOn(testDateYoutube)))
+	assert.NoError(t, err)
+	assert.False(t, present)
+}
+
+func TestLoadPeriodReport(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, a.Config, "140921")
+	a.loader.Workers = 3
+	r, err := a.loader.LoadPeriod(context.Background(), adate("140922"), 3)
+	assert.Equal(t, "Downloading failed for: apod:2014-09-19", err.Error())
+	assert.Equal(t, []Wallpaper{apodOn("140920")}, r.Downloaded)
+	assert.Equal(t, []Wallpaper{apodOn("140921")}, r.Present)
+	assert.Equal(t, 0, len(r.Videos))
+	assert.Equal(t, "downloaded: 1, skipped video: 0, no image: 0, already present: 1, failed: 1\n"+
+		"apod:2014-09-19: Getting http://localhost:8765/apod/ap140919.html returned status: 404 Not Found", r.String())
+}
+
+func TestDelay(t *testing.T) {
+	a := testAPOD()
+	a.Delay = 50 * time.Millisecond
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		a.wait(context.Background(), testAPODSite)
+	}
+	a.wait(context.Background(), "http://example.com/")
+	assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	assert.True(t, time.Since(start) < 150*time.Millisecond)
+
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		a.wait(withDelay(context.Background(), 0), "http://example.org/")
+	}
+	assert.True(t, time.Since(start) < 50*time.Millisecond, "the delay of the context applies")
+	assert.Equal(t, 50*time.Millisecond, a.Delay)
+}
+
+func TestLoadPeriodNoImage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><title>APOD: 2014 September 21 - Nothing</title><body><b>Explanation:</b> Nothing to see.</body></html>")
+	}))
+	defer server.Close()
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	a.APOD.Site = server.URL + "/"
+	r, err := a.loader.LoadPeriod(context.Background(), adate("140922"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140921")}, r.NoImage)
+	assert.Equal(t, 0, len(r.Videos))
+	assert.Equal(t, "downloaded: 0, skipped video: 0, no image: 1, already present: 0, failed: 0", r.String())
+}
+
+func TestDownloadFallsBackToImage(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apod/ap140921.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><title>APOD: 2014 September 21 - Links</title><body><center>`+
+			`<a href="image/1409/interactive.html"><img src="image/1409/m8_chua_2500.jpg"></a></center></body></html>`)
+	})
+	mux.HandleFunc("/apod/ap140920.html", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><title>APOD: 2014 September 20 - Broken</title><body><center>`+
+			`<a href="image/1409/broken.jpg"><img src="image/1409/m8_chua_2500.jpg"></a></center></body></html>`)
+	})
+	mux.HandleFunc("/apod/image/1409/broken.jpg", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html><body>Moved</body></html>")
+	})
+	mux.Handle("/apod/image/", http.FileServer(http.Dir("../testdata/apod.nasa.gov/")))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	a.APOD.Site = server.URL + "/"
+	for _, date := range []string{"140921", "140920"} {
+		loaded, err := a.loader.Download(context.Background(), apodOn(date))
+		assert.NoError(t, err, date)
+		assert.True(t, loaded, date)
+		downloaded, err := a.Config.IsDownloaded(apodOn(date))
+		assert.NoError(t, err, date)
+		assert.True(t, downloaded, date)
+	}
+}
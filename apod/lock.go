@@ -0,0 +1,42 @@
+package apod
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const lockFileBasename = "apod-bg.lock"
+
+func lockFile() string {
+	return filepath.Join(configDir(), lockFileBasename)
+}
+
+// withLock runs fn while holding an advisory lock on the config dir, so that
+// concurrent invocations do not interleave their read-modify-write of the
+// state and the configuration. It must not be nested, as the lock is taken
+// per open file.
+func withLock(fn func() error) error {
+	err := MakeConfigDir()
+	if err != nil {
+		return err
+	}
+	return withFileLock(lockFile(), fn)
+}
+
+// withFileLock runs fn while holding an advisory lock on the file name,
+// which is created if needed.
+func withFileLock(name string, fn func() error) error {
+	fd, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	err = syscall.Flock(int(fd.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return fmt.Errorf("Could not lock %s, because: %v", name, err)
+	}
+	defer syscall.Flock(int(fd.Fd()), syscall.LOCK_UN)
+	return fn()
+}
@@ -0,0 +1,79 @@
+package apod
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithLockExcludes(t *testing.T) {
+	testHome := setupTestHome(t)
+	defer cleanUp(t, testHome)
+	locked := make(chan struct{})
+	events := make(chan string, 3)
+	go withLock(func() error {
+		close(locked)
+		time.Sleep(20 * time.Millisecond)
+		events <- "first released"
+		return nil
+	})
+	<-locked
+	assert.NoError(t, withLock(func() error {
+		events <- "second locked"
+		return nil
+	}))
+	assert.Equal(t, "first released", <-events)
+	assert.Equal(t, "second locked", <-events)
+}
+
+func TestShowDownloadsWithoutLock(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	writeWallpaperScript(setScriptSuccess)
+	w := apodOn(testDateSeptember)
+	locked, release := make(chan struct{}), make(chan struct{})
+	go withLock(func() error {
+		close(locked)
+		<-release
+		return nil
+	})
+	<-locked
+	shown := make(chan error)
+	go func() { shown <- f.Show(context.Background(), w) }()
+	downloaded := false
+	for i := 0; i < 100 && !downloaded; i++ {
+		time.Sleep(10 * time.Millisecond)
+		downloaded, _ = exists(f.Config.metaFileName(w))
+	}
+	assert.True(t, downloaded, "the image is downloaded while another invocation holds the lock")
+	close(release)
+	assert.NoError(t, <-shown)
+	assertShowing(t, f, "apod:2014-09-24 fit")
+}
+
+func TestSeedDownloadsWithoutLock(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	f.Options.NoSeed = false
+	w := apodOn(testDateSeptember)
+	locked, release := make(chan struct{}), make(chan struct{})
+	go withLock(func() error {
+		close(locked)
+		<-release
+		return nil
+	})
+	<-locked
+	seeded := make(chan error)
+	go func() { seeded <- f.Seed(context.Background()) }()
+	downloaded := false
+	for i := 0; i < 100 && !downloaded; i++ {
+		time.Sleep(10 * time.Millisecond)
+		downloaded, _ = exists(f.Config.metaFileName(w))
+	}
+	assert.True(t, downloaded, "the images are seeded while another invocation holds the lock")
+	close(release)
+	assert.NoError(t, <-seeded)
+	assertShowing(t, f, "apod:2014-09-24 fit")
+}
@@ -0,0 +1,154 @@
+package apod
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// The marks a wallpaper can be given.
+const (
+	markFavorite = "favorite"
+	markBanned   = "banned"
+)
+
+// favoriteWeight is how many times likelier a favorite is picked at random.
+const favoriteWeight = 4
+
+const marksBasename = "marks.json"
+
+func marksFile() string {
+	return filepath.Join(configDir(), marksBasename)
+}
+
+// readMarks returns the marks given, by wallpaper.
+func readMarks() (map[string]string, error) {
+	marks := make(map[string]string)
+	bs, err := ioutil.ReadFile(marksFile())
+	if os.IsNotExist(err) {
+		return marks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return marks, json.Unmarshal(bs, &marks)
+}
+
+// storeMark gives w the mark, or takes its mark away if mark is empty.
+func storeMark(w Wallpaper, mark string) error {
+	marks, err := readMarks()
+	if err != nil {
+		return err
+	}
+	if mark == "" {
+		delete(marks, w.String())
+	} else {
+		marks[w.String()] = mark
+	}
+	bs, err := json.Marshal(marks)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(marksFile(), bs, 0644)
+}
+
+// eligible returns the downloaded wallpapers that are not banned, pass the
+// rules and match the search the rotation is restricted to, in chronological
+// order.
+func (f *Frontend) eligible() ([]Wallpaper, error) {
+	all, err := f.storage.DownloadedWallpapers()
+	if err != nil {
+		return nil, err
+	}
+	marks, err := readMarks()
+	if err != nil {
+		return nil, err
+	}
+	var ws []Wallpaper
+	for _, w := range all {
+		if marks[w.String()] != markBanned {
+			ws = append(ws, w)
+		}
+	}
+	if ws, err = f.applyRules(ws); err != nil {
+		return nil, err
+	}
+	return f.inRotation(ws)
+}
+
+// pickRandom picks one of ws at random, each as likely as its weight.
+func pickRandom(ws []Wallpaper, weights []int) Wallpaper {
+	total := 0
+	for _, n := range weights {
+		total += n
+	}
+	n := rand.Intn(total)
+	for i, w := range ws {
+		n -= weights[i]
+		if n < 0 {
+			return w
+		}
+	}
+	return ws[len(ws)-1]
+}
+
+// weight is how many times w is shown in a round of random: favoriteWeight
+// times for a favorite, once for the others.
+func weight(w Wallpaper, marks map[string]string) int {
+	if marks[w.String()] == markFavorite {
+		return favoriteWeight
+	}
+	return 1
+}
+
+// Mark gives the wallpaper shown, on output if it is not empty, the mark or
+// takes its mark away if mark is empty. A banned wallpaper is replaced by the
+// next one at once. It returns the wallpaper marked.
+func (f *Frontend) Mark(output, mark string) (Wallpaper, error) {
+	s, err := f.State()
+	if err != nil {
+		return Wallpaper{}, err
+	}
+	if output != "" {
+		if s, err = s.output(output); err != nil {
+			return Wallpaper{}, err
+		}
+	}
+	w := s.Wallpaper()
+	if err := storeMark(w, mark); err != nil {
+		return w, err
+	}
+	if mark != markBanned {
+		return w, nil
+	}
+	err = f.JumpOutput(output, 1)
+	if err == errEndReached {
+		err = f.JumpOutput(output, -1)
+	}
+	return w, err
+}
+
+// Favorites lists the favorite wallpapers that are downloaded, with their titles.
+func (f *Frontend) Favorites() (string, error) {
+	all, err := f.storage.DownloadedWallpapers()
+	if err != nil {
+		return "", err
+	}
+	marks, err := readMarks()
+	if err != nil {
+		return "", err
+	}
+	var favorites []Wallpaper
+	for _, w := range all {
+		if marks[w.String()] == markFavorite {
+			favorites = append(favorites, w)
+		}
+	}
+	if len(favorites) == 0 {
+		return "", fmt.Errorf("No favorites yet")
+	}
+	return f.describe(favorites), nil
+}
@@ -0,0 +1,83 @@
+package apod
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoreMark(t *testing.T) {
+	_, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	marks, err := readMarks()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(marks))
+	assert.NoError(t, storeMark(apodOn("140120"), markFavorite))
+	assert.NoError(t, storeMark(apodOn("140121"), markBanned))
+	assert.NoError(t, storeMark(apodOn("140120"), ""))
+	marks, err = readMarks()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"apod:2014-01-21": markBanned}, marks)
+}
+
+func TestJumpSkipsBanned(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, f.Config, "140122")
+	assert.NoError(t, storeMark(apodOn("140121"), markBanned))
+	ws, err := f.eligible()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140119"), apodOn("140120"), apodOn("140122")}, ws)
+	assert.NoError(t, f.Jump(1))
+	assertShowing(t, f, "apod:2014-01-22 fit")
+	assert.NoError(t, f.Jump(-1))
+	assertShowing(t, f, "apod:2014-01-20 fit")
+
+	makeStateFile(t, "140121", fit)
+	assert.NoError(t, f.Jump(1))
+	assertShowing(t, f, "apod:2014-01-22 fit")
+	makeStateFile(t, "140121", fit)
+	assert.NoError(t, f.Jump(-1))
+	assertShowing(t, f, "apod:2014-01-20 fit")
+}
+
+func TestCommandBan(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.Command(context.Background(), []string{"ban"}))
+	assertShowing(t, f, "apod:2014-01-21 fit")
+	assert.NoError(t, f.Command(context.Background(), []string{"ban"}))
+	assertShowing(t, f, "apod:2014-01-19 fit")
+	assert.Equal(t, errEndReached, f.Jump(1))
+	assert.NoError(t, f.Command(context.Background(), []string{"unfav"}))
+	marks, err := readMarks()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(marks))
+}
+
+func TestPickRandom(t *testing.T) {
+	ws := []Wallpaper{apodOn("140119"), apodOn("140120")}
+	marks := map[string]string{"apod:2014-01-20": markFavorite}
+	weights := []int{weight(ws[0], marks), weight(ws[1], marks)}
+	count := 0
+	for i := 0; i < 1000; i++ {
+		if pickRandom(ws, weights) == ws[1] {
+			count++
+		}
+	}
+	assert.InDelta(t, 800, count, 100, "a favorite is picked four times as often")
+}
+
+func TestFavorites(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	_, err := f.Favorites()
+	assert.Equal(t, "No favorites yet", err.Error())
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: apodOn("140120").Date, Title: "M8"}))
+	assert.NoError(t, f.Command(context.Background(), []string{"fav"}))
+	assert.NoError(t, storeMark(apodOn("140119"), markFavorite))
+	list, err := f.Favorites()
+	assert.NoError(t, err)
+	assert.Equal(t, "apod:2014-01-19\napod:2014-01-20 M8\n", list)
+}
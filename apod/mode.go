@@ -0,0 +1,128 @@
+package apod
+
+import (
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// modeAuto has the options of every image chosen by its aspect ratio.
+const modeAuto = "auto"
+
+// defaultAutoCrop is the percentage of an image zoom may crop in auto mode.
+const defaultAutoCrop = 15
+
+const overridesBasename = "overrides.json"
+
+func overridesFile() string {
+	return filepath.Join(configDir(), overridesBasename)
+}
+
+// readOverrides returns the options chosen by hand, by wallpaper.
+func readOverrides() (map[string]string, error) {
+	overrides := make(map[string]string)
+	bs, err := ioutil.ReadFile(overridesFile())
+	if os.IsNotExist(err) {
+		return overrides, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return overrides, json.Unmarshal(bs, &overrides)
+}
+
+// storeOverride remembers the options chosen by hand for the wallpapers.
+func storeOverride(options string, ws ...Wallpaper) error {
+	overrides, err := readOverrides()
+	if err != nil {
+		return err
+	}
+	for _, w := range ws {
+		overrides[w.String()] = options
+	}
+	bs, err := json.Marshal(overrides)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(overridesFile(), bs, 0644)
+}
+
+// optionsFor returns the options to show w with on a screen of size screen:
+// the ones chosen by hand for it, else the ones of the configured mode.
+// Without a mode the options of the image shown before, last, are kept.
+func (f *Frontend) optionsFor(w Wallpaper, last string, screen image.Point) string {
+	overrides, err := readOverrides()
+	if err != nil {
+		f.Log.Printf("Could not read the options chosen by hand, because: %v\n", err)
+	}
+	if options, ok := overrides[w.String()]; ok {
+		return options
+	}
+	switch f.Config.Mode {
+	case fit, zoom:
+		return f.Config.Mode
+	case modeAuto:
+		return f.autoOptions(w, screen)
+	}
+	if last == "" {
+		return fit
+	}
+	return last
+}
+
+// stateFor returns the state showing w, with the options for it on a screen
+// of size screen.
+func (f *Frontend) stateFor(w Wallpaper, screen image.Point) State {
+	last := ""
+	if s, err := f.State(); err == nil {
+		last = s.Options
+	}
+	return newState(w, f.optionsFor(w, last, screen))
+}
+
+// modeScreen returns the size of the screen of monitors for optionsFor. It
+// is zero without auto mode, which is the only mode that needs it.
+func (f *Frontend) modeScreen(monitors []Monitor) image.Point {
+	if f.Config.Mode != modeAuto {
+		return image.Point{}
+	}
+	return f.sizeOf(monitors)
+}
+
+// autoOptions returns zoom if that crops no more of w than configured to
+// fill a screen of size screen, and fit otherwise, or if the sizes are not known.
+func (f *Frontend) autoOptions(w Wallpaper, screen image.Point) string {
+	if screen.X <= 0 || screen.Y <= 0 {
+		return fit
+	}
+	file := f.Config.fileName(w)
+	if present, _ := exists(file); !present {
+		return fit
+	}
+	size, err := imageSize(file)
+	if err != nil || size.X <= 0 || size.Y <= 0 {
+		f.Log.Printf("Could not read the size of %s, because: %v\n", w, err)
+		return fit
+	}
+	maxCrop := f.Config.AutoCrop
+	if maxCrop <= 0 {
+		maxCrop = defaultAutoCrop
+	}
+	if cropLoss(size, screen) <= maxCrop {
+		return zoom
+	}
+	return fit
+}
+
+// cropLoss returns the percentage of an image of size img that is cut off
+// when it is zoomed to fill a screen of size screen.
+func cropLoss(img, screen image.Point) float64 {
+	imgAspect := float64(img.X) / float64(img.Y)
+	screenAspect := float64(screen.X) / float64(screen.Y)
+	if imgAspect > screenAspect {
+		return 100 * (1 - screenAspect/imgAspect)
+	}
+	return 100 * (1 - imgAspect/screenAspect)
+}
@@ -0,0 +1,96 @@
+package apod
+
+import (
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCropLoss(t *testing.T) {
+	assert.InDelta(t, 0, cropLoss(image.Pt(3200, 1800), image.Pt(1920, 1080)), 0.01)
+	assert.InDelta(t, 25, cropLoss(image.Pt(1600, 1200), image.Pt(1920, 1080)), 0.01)
+	assert.InDelta(t, 68.36, cropLoss(image.Pt(1000, 1778), image.Pt(1920, 1080)), 0.01)
+}
+
+func TestOptionsForAuto(t *testing.T) {
+	f, _, testHome := frontendForTestMonitors(t, monitorsSame)
+	defer cleanUp(t, testHome)
+	f.Config.Mode = modeAuto
+	monitors, err := listMonitors(f.Config.Setter, f.run)
+	assert.NoError(t, err)
+	screen := f.modeScreen(monitors)
+	assert.Equal(t, image.Pt(2560, 1440), screen)
+	wide, tall := apodOn("140921"), apodOn("140920")
+	writeTestImage(t, f.Config.fileName(wide), image.NewRGBA(image.Rect(0, 0, 170, 100)))
+	writeTestImage(t, f.Config.fileName(tall), image.NewRGBA(image.Rect(0, 0, 100, 170)))
+	assert.Equal(t, zoom, f.optionsFor(wide, fit, screen))
+	assert.Equal(t, fit, f.optionsFor(tall, zoom, screen))
+	assert.Equal(t, fit, f.optionsFor(apodOn("140923"), zoom, screen), "an unreadable image is fitted")
+	f.Config.AutoCrop = 80
+	assert.Equal(t, zoom, f.optionsFor(tall, fit, screen))
+}
+
+func TestOptionsForMode(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	w := apodOn("140921")
+	assert.Equal(t, zoom, f.optionsFor(w, zoom, image.Point{}), "the last options are kept")
+	assert.Equal(t, fit, f.optionsFor(w, "", image.Point{}))
+	f.Config.Mode = fit
+	assert.Equal(t, fit, f.optionsFor(w, zoom, image.Point{}))
+	assert.NoError(t, storeOverride(zoom, w))
+	assert.Equal(t, zoom, f.optionsFor(w, fit, image.Point{}), "the options chosen by hand stick")
+	assert.Equal(t, fit, f.optionsFor(apodOn("140920"), zoom, image.Point{}))
+}
+
+func TestToggleViewModeSticks(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	f.Config.Mode = fit
+	_, err := f.ToggleViewMode()
+	assert.NoError(t, err)
+	assert.NoError(t, f.Jump(1))
+	assertShowing(t, f, "apod:2014-01-21 fit")
+	assert.NoError(t, f.Jump(-1))
+	assertShowing(t, f, "apod:2014-01-20 zoom")
+}
+
+func TestAutoListsMonitorsOnce(t *testing.T) {
+	f, _, testHome := frontendForTestMonitors(t, monitorsDifferent)
+	defer cleanUp(t, testHome)
+	f.Config.Mode = modeAuto
+	xrandr := 0
+	run := f.run
+	f.run = func(name string, args ...string) ([]byte, error) {
+		if name == "xrandr" {
+			xrandr++
+		}
+		return run(name, args...)
+	}
+	assert.NoError(t, f.show(apodOn("140923")))
+	assert.Equal(t, 1, xrandr, "once for both the state and the outputs")
+}
+
+func TestAutoPerMonitor(t *testing.T) {
+	f, _, testHome := frontendForTestMonitors(t, monitorsDifferent)
+	defer cleanUp(t, testHome)
+	f.Config.Mode = modeAuto
+	f.Config.Setter = "xwallpaper"
+	r := &fakeRunner{}
+	f.run = func(name string, args ...string) ([]byte, error) {
+		if name == "xrandr" {
+			return []byte("HDMI-1 connected 1920x1080+0+0 (normal left inverted right x axis y axis) 527mm x 296mm\n" +
+				"DP-1 connected 1080x1920+1920+0 (normal left inverted right x axis y axis) 296mm x 527mm\n"), nil
+		}
+		return r.run(name, args...)
+	}
+	wide, tall := apodOn("140923"), apodOn("140921")
+	writeTestImage(t, f.Config.fileName(wide), image.NewRGBA(image.Rect(0, 0, 170, 100)))
+	writeTestImage(t, f.Config.fileName(tall), image.NewRGBA(image.Rect(0, 0, 100, 170)))
+	assert.NoError(t, f.show(wide))
+	s, err := f.State()
+	assert.NoError(t, err)
+	assert.Equal(t, newState(wide, zoom), s.Outputs["HDMI-1"])
+	assert.Equal(t, newState(tall, zoom), s.Outputs["DP-1"], "the portrait monitor zooms the portrait image")
+}
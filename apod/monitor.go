@@ -0,0 +1,295 @@
+package apod
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The monitor modes of the configuration: every output shows the same
+// image, each output shows its own image, or one image spans all outputs.
+const (
+	monitorsSame      = "same"
+	monitorsDifferent = "different"
+	monitorsSpan      = "span"
+)
+
+// Monitor is an output of the display with its place on the desktop.
+type Monitor struct {
+	Name          string
+	X, Y          int
+	Width, Height int
+}
+
+// Output is the image and its options for one monitor.
+type Output struct {
+	Monitor
+	File    string
+	Options string
+}
+
+// OutputSetter sets a different image on each output.
+type OutputSetter interface {
+	SetOutputs(outputs []Output) error
+}
+
+// Spanner sets one image stretched over all outputs.
+type Spanner interface {
+	Span(file, options string) error
+}
+
+// xrandrMonitor matches the line of a connected output with its geometry,
+// like: DP-1 connected primary 2560x1440+1920+0 (normal left ...) 597mm x 336mm
+var xrandrMonitor = regexp.MustCompile(`^(\S+) connected (?:primary )?(\d+)x(\d+)\+(\d+)\+(\d+)`)
+
+// parseXrandr returns the active monitors listed by xrandr --query.
+func parseXrandr(output string) []Monitor {
+	var monitors []Monitor
+	for _, line := range strings.Split(output, "\n") {
+		m := xrandrMonitor.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		var n [4]int
+		for i := range n {
+			n[i], _ = strconv.Atoi(m[i+2])
+		}
+		monitors = append(monitors, Monitor{Name: m[1], Width: n[0], Height: n[1], X: n[2], Y: n[3]})
+	}
+	sortMonitors(monitors)
+	return monitors
+}
+
+// parseSwayOutputs returns the active monitors of swaymsg -t get_outputs -r.
+func parseSwayOutputs(output []byte) ([]Monitor, error) {
+	var outputs []struct {
+		Name   string
+		Active bool
+		Rect   struct{ X, Y, Width, Height int }
+	}
+	if err := json.Unmarshal(output, &outputs); err != nil {
+		return nil, fmt.Errorf("Could not parse the outputs of sway, because: %v", err)
+	}
+	var monitors []Monitor
+	for _, o := range outputs {
+		if o.Active {
+			monitors = append(monitors, Monitor{Name: o.Name, X: o.Rect.X, Y: o.Rect.Y, Width: o.Rect.Width, Height: o.Rect.Height})
+		}
+	}
+	sortMonitors(monitors)
+	return monitors, nil
+}
+
+// parseHyprctlMonitors returns the monitors of hyprctl monitors -j.
+func parseHyprctlMonitors(output []byte) ([]Monitor, error) {
+	var monitors []Monitor
+	if err := json.Unmarshal(output, &monitors); err != nil {
+		return nil, fmt.Errorf("Could not parse the monitors of Hyprland, because: %v", err)
+	}
+	sortMonitors(monitors)
+	return monitors, nil
+}
+
+// sortMonitors orders the monitors from left to right, then top to bottom.
+func sortMonitors(monitors []Monitor) {
+	sort.SliceStable(monitors, func(i, j int) bool {
+		if monitors[i].X != monitors[j].X {
+			return monitors[i].X < monitors[j].X
+		}
+		return monitors[i].Y < monitors[j].Y
+	})
+}
+
+// listMonitors asks the compositor of the setter, or else xrandr, for the
+// active monitors.
+func listMonitors(setter string, r runner) ([]Monitor, error) {
+	var monitors []Monitor
+	var err error
+	switch setter {
+	case "sway":
+		output, runErr := r("swaymsg", "-t", "get_outputs", "-r")
+		if runErr != nil {
+			return nil, fmt.Errorf("Error running swaymsg: %v. Output: %s", runErr, string(output))
+		}
+		monitors, err = parseSwayOutputs(output)
+	case "hyprpaper":
+		output, runErr := r("hyprctl", "monitors", "-j")
+		if runErr != nil {
+			return nil, fmt.Errorf("Error running hyprctl: %v. Output: %s", runErr, string(output))
+		}
+		monitors, err = parseHyprctlMonitors(output)
+	default:
+		output, runErr := r("xrandr", "--query")
+		if runErr != nil {
+			return nil, fmt.Errorf("Error running xrandr: %v. Output: %s", runErr, string(output))
+		}
+		monitors = parseXrandr(string(output))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(monitors) == 0 {
+		return nil, fmt.Errorf("No monitors found")
+	}
+	return monitors, nil
+}
+
+// assignOutputs gives every monitor the wallpaper it showed before, if its
+// image is still there, and the others the wallpapers preceding the one of s,
+// so that no two monitors show the same image if enough are downloaded. The
+// options of a wallpaper newly shown on a monitor are given by options.
+func assignOutputs(s State, monitors []Monitor, all []Wallpaper, options func(Wallpaper, Monitor) string) map[string]State {
+	idx := len(all) - 1
+	for i, w := range all {
+		if w == s.Wallpaper() {
+			idx = i
+		}
+	}
+	outputs := make(map[string]State)
+	for i, m := range monitors {
+		if o, ok := s.Outputs[m.Name]; ok && containsWallpaper(all, o.Wallpaper()) {
+			outputs[m.Name] = o
+			continue
+		}
+		n := len(all)
+		w := all[((idx-i)%n+n)%n]
+		outputs[m.Name] = newState(w, options(w, m))
+	}
+	return outputs
+}
+
+func containsWallpaper(all []Wallpaper, w Wallpaper) bool {
+	for _, v := range all {
+		if v == w {
+			return true
+		}
+	}
+	return false
+}
+
+// SetOutputs hands feh one image per Xinerama screen, feh sizes them all
+// alike so the options of the first output apply.
+func (s fehSetter) SetOutputs(outputs []Output) error {
+	args := []string{"--bg-max"}
+	if outputs[0].Options == zoom {
+		args[0] = "--bg-fill"
+	}
+	for _, o := range outputs {
+		args = append(args, o.File)
+	}
+	return s.run.run("feh", args...)
+}
+
+func (s fehSetter) Span(file, options string) error {
+	if options == zoom {
+		return s.run.run("feh", "--no-xinerama", "--bg-fill", file)
+	}
+	return s.run.run("feh", "--no-xinerama", "--bg-max", file)
+}
+
+func (s pcmanfmSetter) Span(file, options string) error {
+	return s.run.run("pcmanfm", "--set-wallpaper="+file, "--wallpaper-mode=screen")
+}
+
+func (s gsettingsSetter) Span(file, options string) error {
+	if err := s.Set(file, options); err != nil {
+		return err
+	}
+	return s.run.run("gsettings", "set", "org.gnome.desktop.background", "picture-options", "spanned")
+}
+
+func (s swaySetter) SetOutputs(outputs []Output) error {
+	for _, o := range outputs {
+		mode := "fit"
+		if o.Options == zoom {
+			mode = "fill"
+		}
+		if err := s.run.run("swaymsg", "output", o.Name, "bg", o.File, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s hyprpaperSetter) SetOutputs(outputs []Output) error {
+	for _, o := range outputs {
+		if err := s.run.run("hyprctl", "hyprpaper", "preload", o.File); err != nil {
+			return err
+		}
+		target := o.File
+		if o.Options != zoom {
+			target = "contain:" + o.File
+		}
+		if err := s.run.run("hyprctl", "hyprpaper", "wallpaper", o.Name+","+target); err != nil {
+			return err
+		}
+	}
+	return s.run.run("hyprctl", "hyprpaper", "unload", "unused")
+}
+
+func (s xwallpaperSetter) SetOutputs(outputs []Output) error {
+	var args []string
+	for _, o := range outputs {
+		mode := "--maximize"
+		if o.Options == zoom {
+			mode = "--zoom"
+		}
+		args = append(args, "--output", o.Name, mode, o.File)
+	}
+	return s.run.run("xwallpaper", args...)
+}
+
+// SetOutputs sets the heads of nitrogen, which counts them in Xinerama order.
+func (s nitrogenSetter) SetOutputs(outputs []Output) error {
+	for i, o := range outputs {
+		mode := "--set-scaled"
+		if o.Options == zoom {
+			mode = "--set-zoom-fill"
+		}
+		if err := s.run.run("nitrogen", fmt.Sprintf("--head=%d", i), mode, "--save", o.File); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// monitorsFor lists the monitors if setting a wallpaper needs them: to choose
+// the options in auto mode, to render at their size or to show each its own
+// image. It returns none otherwise.
+func (f *Frontend) monitorsFor() ([]Monitor, error) {
+	if f.Config.Mode != modeAuto && f.Config.renderMode() == "" && f.Config.Monitors != monitorsDifferent {
+		return nil, nil
+	}
+	return listMonitors(f.Config.Setter, f.run)
+}
+
+// setOutputs shows the images of s on monitors, each its own, and returns s
+// with the image of every monitor recorded.
+func (f *Frontend) setOutputs(setter Setter, s State, monitors []Monitor) (State, error) {
+	outputSetter, ok := setter.(OutputSetter)
+	if !ok {
+		return s, fmt.Errorf("The %s setter can not show different images on the monitors", f.Config.Setter)
+	}
+	all, err := f.eligible()
+	if err != nil {
+		return s, err
+	}
+	if len(all) == 0 {
+		return s, fmt.Errorf("No backgrounds downloaded yet")
+	}
+	s.Outputs = assignOutputs(s, monitors, all, func(w Wallpaper, m Monitor) string {
+		if w == s.Wallpaper() {
+			return s.Options
+		}
+		return f.optionsFor(w, s.Options, m.size())
+	})
+	var outputs []Output
+	for _, m := range monitors {
+		o := s.Outputs[m.Name]
+		outputs = append(outputs, Output{Monitor: m, File: f.screenFile(o.Wallpaper(), m.size()), Options: o.Options})
+	}
+	return s, outputSetter.SetOutputs(outputs)
+}
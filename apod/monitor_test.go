@@ -0,0 +1,144 @@
+package apod
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testXrandr = `Screen 0: minimum 320 x 200, current 4480 x 1440, maximum 16384 x 16384
+DP-1 connected primary 2560x1440+1920+0 (normal left inverted right x axis y axis) 597mm x 336mm
+   2560x1440     59.95*+
+HDMI-1 connected 1920x1080+0+180 (normal left inverted right x axis y axis) 527mm x 296mm
+   1920x1080     60.00*+
+HDMI-2 disconnected (normal left inverted right x axis y axis)
+DP-2 connected (normal left inverted right x axis y axis)
+`
+
+const testSwayOutputs = `[
+	{"name": "eDP-1", "active": true, "rect": {"x": 0, "y": 0, "width": 1920, "height": 1080}},
+	{"name": "DP-3", "active": false, "rect": {"x": 0, "y": 0, "width": 0, "height": 0}},
+	{"name": "DP-4", "active": true, "rect": {"x": 1920, "y": 0, "width": 2560, "height": 1440}}
+]`
+
+func TestParseXrandr(t *testing.T) {
+	assert.Equal(t, []Monitor{
+		{Name: "HDMI-1", X: 0, Y: 180, Width: 1920, Height: 1080},
+		{Name: "DP-1", X: 1920, Y: 0, Width: 2560, Height: 1440},
+	}, parseXrandr(testXrandr))
+}
+
+func TestParseSwayOutputs(t *testing.T) {
+	monitors, err := parseSwayOutputs([]byte(testSwayOutputs))
+	assert.NoError(t, err)
+	assert.Equal(t, []Monitor{
+		{Name: "eDP-1", X: 0, Y: 0, Width: 1920, Height: 1080},
+		{Name: "DP-4", X: 1920, Y: 0, Width: 2560, Height: 1440},
+	}, monitors)
+	_, err = parseSwayOutputs([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestListMonitorsNone(t *testing.T) {
+	r := &fakeRunner{output: "Screen 0: minimum 320 x 200\n"}
+	_, err := listMonitors("feh", r.run)
+	assert.Equal(t, "No monitors found", err.Error())
+	assert.Equal(t, []string{"xrandr --query"}, r.calls)
+}
+
+func TestAssignOutputs(t *testing.T) {
+	all := []Wallpaper{apodOn("140920"), apodOn("140921"), apodOn("140923")}
+	monitors := parseXrandr(testXrandr)
+	s := newState(apodOn("140921"), zoom)
+	options := func(Wallpaper, Monitor) string { return zoom }
+	outputs := assignOutputs(s, monitors, all, options)
+	assert.Equal(t, newState(apodOn("140921"), zoom), outputs["HDMI-1"])
+	assert.Equal(t, newState(apodOn("140920"), zoom), outputs["DP-1"])
+
+	s.Outputs = map[string]State{"DP-1": newState(apodOn("140923"), fit), "gone": newState(apodOn("140920"), fit)}
+	outputs = assignOutputs(s, monitors, all, options)
+	assert.Equal(t, newState(apodOn("140923"), fit), outputs["DP-1"])
+	assert.Equal(t, 2, len(outputs))
+}
+
+func TestOutputSetters(t *testing.T) {
+	outputs := []Output{
+		{Monitor: Monitor{Name: "HDMI-1"}, File: "/w/a", Options: zoom},
+		{Monitor: Monitor{Name: "DP-1"}, File: "/w/b", Options: fit},
+	}
+	for name, calls := range map[string][]string{
+		"feh":        {"feh --bg-fill /w/a /w/b"},
+		"sway":       {"swaymsg output HDMI-1 bg /w/a fill", "swaymsg output DP-1 bg /w/b fit"},
+		"xwallpaper": {"xwallpaper --output HDMI-1 --zoom /w/a --output DP-1 --maximize /w/b"},
+		"nitrogen":   {"nitrogen --head=0 --set-zoom-fill --save /w/a", "nitrogen --head=1 --set-scaled --save /w/b"},
+		"hyprpaper": {
+			"hyprctl hyprpaper preload /w/a", "hyprctl hyprpaper wallpaper HDMI-1,/w/a",
+			"hyprctl hyprpaper preload /w/b", "hyprctl hyprpaper wallpaper DP-1,contain:/w/b",
+			"hyprctl hyprpaper unload unused"},
+	} {
+		r := &fakeRunner{}
+		s, err := newSetter(name, r.run)
+		assert.NoError(t, err)
+		assert.NoError(t, s.(OutputSetter).SetOutputs(outputs), name)
+		assert.Equal(t, calls, r.calls, name)
+	}
+}
+
+func TestSpanners(t *testing.T) {
+	r := &fakeRunner{}
+	s, _ := newSetter("feh", r.run)
+	assert.NoError(t, s.(Spanner).Span("/w/a", zoom))
+	assert.Equal(t, []string{"feh --no-xinerama --bg-fill /w/a"}, r.calls)
+	s, _ = newSetter("sway", r.run)
+	_, ok := s.(Spanner)
+	assert.False(t, ok)
+}
+
+func frontendForTestMonitors(t *testing.T, mode string) (*Frontend, *fakeRunner, string) {
+	f, testHome := frontendForTestConfigured(t)
+	r := &fakeRunner{}
+	f.run = func(name string, args ...string) ([]byte, error) {
+		if name == "xrandr" {
+			return []byte(testXrandr), nil
+		}
+		return r.run(name, args...)
+	}
+	f.Config.Setter = "feh"
+	f.Config.Monitors = mode
+	makeTestWallpapers(t, f.Config, "140920", "140921", "140923")
+	return f, r, testHome
+}
+
+func TestSetWallpaperDifferent(t *testing.T) {
+	f, r, testHome := frontendForTestMonitors(t, monitorsDifferent)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.SetWallpaper(newState(apodOn("140923"), fit)))
+	assert.Equal(t, 1, len(r.calls))
+	assert.True(t, strings.HasSuffix(r.calls[0], "apod-img-2014-09-23 "+f.Config.fileName(apodOn("140921"))), r.calls[0])
+
+	assert.NoError(t, f.JumpOutput("DP-1", -1))
+	s, err := f.State()
+	assert.NoError(t, err)
+	assert.Equal(t, apodOn("140923"), s.Outputs["HDMI-1"].Wallpaper())
+	assert.Equal(t, apodOn("140920"), s.Outputs["DP-1"].Wallpaper())
+	assert.Equal(t, apodOn("140923"), s.Wallpaper())
+	status, err := f.Status()
+	assert.NoError(t, err)
+	assert.Equal(t, "apod:2014-09-23 fit\nDP-1: apod:2014-09-20 fit\nHDMI-1: apod:2014-09-23 fit", status)
+	h, err := f.readHistory()
+	assert.NoError(t, err)
+	assert.Equal(t, apodOn("140920"), h.Visits[h.Current].Wallpaper, "the history records the wallpaper of the output")
+
+	assert.Equal(t, errBeginReached, f.JumpOutput("DP-1", -1))
+	assert.Equal(t, "Unknown output: VGA-1", f.JumpOutput("VGA-1", 1).Error())
+}
+
+func TestSetWallpaperSpan(t *testing.T) {
+	f, r, testHome := frontendForTestMonitors(t, monitorsSpan)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.SetWallpaper(newState(apodOn("140923"), zoom)))
+	assert.Equal(t, []string{"feh --no-xinerama --bg-fill " + f.Config.fileName(apodOn("140923"))}, r.calls)
+	f.Config.Setter = "sway"
+	assert.Equal(t, "The sway setter can not span an image over the monitors", f.SetWallpaper(newState(apodOn("140923"), zoom)).Error())
+}
@@ -0,0 +1,311 @@
+package apod
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// The render modes of the configuration: the whole image over a blurred
+// copy of itself, a crop around its most detailed part, or a crop of its
+// center.
+const (
+	renderBlur      = "blur"
+	renderSmartCrop = "smartcrop"
+	renderCenter    = "center"
+)
+
+// renditionMaxAge is how long a rendition is kept after it was last used.
+const renditionMaxAge = 30 * 24 * time.Hour
+
+// blurScale is how many times smaller the blurred background is rendered
+// before it is scaled up again.
+const blurScale = 32
+
+func renditionDir() string {
+	return filepath.Join(configDir(), "renditions")
+}
+
+// rendition returns the file of the rendition of the image in src at the
+// given size, with the caption of o if it is not nil. It is rendered at first
+// use and rendered again if src changes. Renditions unused for a while are
+// removed.
+func rendition(src, mode string, width, height int, o *overlay) (string, error) {
+	switch mode {
+	case renderBlur, renderSmartCrop, renderCenter:
+	default:
+		return "", fmt.Errorf("Unknown render mode: %s", mode)
+	}
+	base := filepath.Base(src)
+	name := fmt.Sprintf("%s-%s-%dx%d", base, mode, width, height)
+	if o != nil {
+		name += "-" + o.key()
+	}
+	file := filepath.Join(renditionDir(), name+".jpg")
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	if info, err := os.Stat(file); err == nil && !info.ModTime().Before(srcInfo.ModTime()) {
+		now := time.Now()
+		return file, os.Chtimes(file, now, now)
+	}
+	img, err := decodeImage(src)
+	if err != nil {
+		return "", err
+	}
+	dst := render(img, mode, width, height)
+	if o != nil {
+		if err := o.draw(dst); err != nil {
+			return "", err
+		}
+	}
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 92})
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(renditionDir(), 0700); err != nil {
+		return "", err
+	}
+	pruneRenditions()
+	return file, writeFileAtomic(file, buf.Bytes(), 0644)
+}
+
+// pruneRenditions removes the renditions that were not used for renditionMaxAge.
+func pruneRenditions() {
+	infos, err := ioutil.ReadDir(renditionDir())
+	if err != nil {
+		return
+	}
+	for _, info := range infos {
+		if time.Since(info.ModTime()) > renditionMaxAge {
+			os.Remove(filepath.Join(renditionDir(), info.Name()))
+		}
+	}
+}
+
+func decodeImage(file string) (image.Image, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("Could not decode %s, because: %v", file, err)
+	}
+	return img, nil
+}
+
+// render returns img brought to the size according to mode.
+func render(img image.Image, mode string, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	switch mode {
+	case renderBlur:
+		draw.Draw(dst, dst.Bounds(), blurred(img, width, height), image.Point{}, draw.Src)
+		draw.CatmullRom.Scale(dst, fitRect(img.Bounds(), width, height), img, img.Bounds(), draw.Over, nil)
+	case renderSmartCrop:
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, smartCrop(img, width, height), draw.Src, nil)
+	default:
+		crop := cropSize(img.Bounds(), width, height)
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, centerIn(crop, img.Bounds()), draw.Src, nil)
+	}
+	return dst
+}
+
+// fitRect returns the largest rectangle with the aspect of src centered in
+// a width by height area.
+func fitRect(src image.Rectangle, width, height int) image.Rectangle {
+	w, h := width, src.Dy()*width/src.Dx()
+	if h > height {
+		w, h = src.Dx()*height/src.Dy(), height
+	}
+	return centerIn(image.Rect(0, 0, w, h), image.Rect(0, 0, width, height))
+}
+
+// cropSize returns the largest rectangle at the origin within src that has
+// the aspect of width by height.
+func cropSize(src image.Rectangle, width, height int) image.Rectangle {
+	w, h := src.Dx(), src.Dx()*height/width
+	if h > src.Dy() {
+		w, h = src.Dy()*width/height, src.Dy()
+	}
+	return image.Rect(0, 0, w, h)
+}
+
+// centerIn moves r to the center of outer.
+func centerIn(r, outer image.Rectangle) image.Rectangle {
+	return r.Add(outer.Min.Add(image.Pt((outer.Dx()-r.Dx())/2, (outer.Dy()-r.Dy())/2)))
+}
+
+// blurred returns img cropped to cover width by height and blurred, by
+// scaling it down and up again.
+func blurred(img image.Image, width, height int) image.Image {
+	crop := centerIn(cropSize(img.Bounds(), width, height), img.Bounds())
+	small := image.NewRGBA(image.Rect(0, 0, width/blurScale+1, height/blurScale+1))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, crop, draw.Src, nil)
+	small = boxBlur(boxBlur(small))
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(dst, dst.Bounds(), small, small.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// boxBlur averages every pixel with its neighbours.
+func boxBlur(img *image.RGBA) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(b)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var r, g, bl, n int
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					p := image.Pt(x+dx, y+dy)
+					if !p.In(b) {
+						continue
+					}
+					c := img.RGBAAt(p.X, p.Y)
+					r, g, bl, n = r+int(c.R), g+int(c.G), bl+int(c.B), n+1
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), 0xff})
+		}
+	}
+	return dst
+}
+
+// smartCrop returns the crop with the aspect of width by height that holds
+// the most detail of img, measured as the change in brightness between
+// neighbouring samples.
+func smartCrop(img image.Image, width, height int) image.Rectangle {
+	b := img.Bounds()
+	crop := cropSize(b, width, height)
+	horizontal := crop.Dx() < b.Dx()
+	if !horizontal && crop.Dy() == b.Dy() {
+		return crop.Add(b.Min)
+	}
+	// sample about 256 points along the longest side
+	step := b.Dx()
+	if b.Dy() > step {
+		step = b.Dy()
+	}
+	step = step/256 + 1
+	lum := func(x, y int) int {
+		return int(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+	}
+	var profile []int
+	if horizontal {
+		for x := b.Min.X; x+step < b.Max.X; x += step {
+			e := 0
+			for y := b.Min.Y; y+step < b.Max.Y; y += step {
+				e += abs(lum(x+step, y)-lum(x, y)) + abs(lum(x, y+step)-lum(x, y))
+			}
+			profile = append(profile, e)
+		}
+	} else {
+		for y := b.Min.Y; y+step < b.Max.Y; y += step {
+			e := 0
+			for x := b.Min.X; x+step < b.Max.X; x += step {
+				e += abs(lum(x+step, y)-lum(x, y)) + abs(lum(x, y+step)-lum(x, y))
+			}
+			profile = append(profile, e)
+		}
+	}
+	window := crop.Dx() / step
+	free := b.Dx() - crop.Dx()
+	if !horizontal {
+		window, free = crop.Dy()/step, b.Dy()-crop.Dy()
+	}
+	best, bestSum, sum := 0, -1, 0
+	for i, e := range profile {
+		sum += e
+		if i >= window {
+			sum -= profile[i-window]
+		}
+		if start := i - window + 1; start >= 0 && sum > bestSum {
+			best, bestSum = start, sum
+		}
+	}
+	offset := best * step
+	if offset > free {
+		offset = free
+	}
+	if horizontal {
+		return crop.Add(b.Min).Add(image.Pt(offset, 0))
+	}
+	return crop.Add(b.Min).Add(image.Pt(0, offset))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// size returns the size of the monitor.
+func (m Monitor) size() image.Point {
+	return image.Pt(m.Width, m.Height)
+}
+
+// screenSize returns the size to render the images at on monitors, zero if
+// renditions are not configured.
+func (f *Frontend) screenSize(monitors []Monitor) image.Point {
+	if f.Config.renderMode() == "" {
+		return image.Point{}
+	}
+	return f.sizeOf(monitors)
+}
+
+// sizeOf returns the size of the largest of monitors, or of the desktop if
+// the image spans all monitors. It is zero if there are no monitors.
+func (f *Frontend) sizeOf(monitors []Monitor) image.Point {
+	var size image.Point
+	var desktop image.Rectangle
+	for _, m := range monitors {
+		if m.Width*m.Height > size.X*size.Y {
+			size = m.size()
+		}
+		desktop = desktop.Union(image.Rect(m.X, m.Y, m.X+m.Width, m.Y+m.Height))
+	}
+	if f.Config.Monitors == monitorsSpan {
+		return desktop.Size()
+	}
+	return size
+}
+
+// renderMode returns the configured render mode. Captions are drawn on
+// renditions, so with a caption it is blur unless configured otherwise.
+func (c *config) renderMode() string {
+	if c.Render == "" && c.Caption != nil {
+		return renderBlur
+	}
+	return c.Render
+}
+
+// screenFile returns the file to hand the setter for w on a screen of the
+// given size: its rendition if renditions are configured, else, or if
+// rendering fails, the downloaded image.
+func (f *Frontend) screenFile(w Wallpaper, size image.Point) string {
+	file := f.Config.fileName(w)
+	mode := f.Config.renderMode()
+	if mode == "" || size.X <= 0 || size.Y <= 0 {
+		return file
+	}
+	r, err := rendition(file, mode, size.X, size.Y, f.overlay(w))
+	if err != nil {
+		f.Log.Printf("Could not render %v, showing the original instead, because: %v\n", w, err)
+		return file
+	}
+	return r
+}
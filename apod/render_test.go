@@ -0,0 +1,115 @@
+package apod
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// detailedImage returns a flat gray image with noise in the rectangle detail.
+func detailedImage(bounds, detail image.Rectangle) *image.RGBA {
+	img := image.NewRGBA(bounds)
+	r := rand.New(rand.NewSource(1))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			v := uint8(128)
+			if image.Pt(x, y).In(detail) {
+				v = uint8(r.Intn(256))
+			}
+			img.SetRGBA(x, y, color.RGBA{v, v, v, 0xff})
+		}
+	}
+	return img
+}
+
+func TestFitRect(t *testing.T) {
+	assert.Equal(t, image.Rect(0, 25, 200, 75), fitRect(image.Rect(0, 0, 400, 100), 200, 100))
+	assert.Equal(t, image.Rect(75, 0, 125, 100), fitRect(image.Rect(0, 0, 100, 200), 200, 100))
+}
+
+func TestCropSize(t *testing.T) {
+	assert.Equal(t, image.Rect(0, 0, 200, 100), cropSize(image.Rect(0, 0, 400, 100), 200, 100))
+	assert.Equal(t, image.Rect(0, 0, 100, 50), cropSize(image.Rect(0, 0, 100, 200), 200, 100))
+}
+
+func TestSmartCrop(t *testing.T) {
+	img := detailedImage(image.Rect(0, 0, 400, 100), image.Rect(300, 0, 400, 100))
+	crop := smartCrop(img, 200, 100)
+	assert.Equal(t, image.Pt(200, 100), crop.Size())
+	assert.True(t, crop.Min.X >= 190, "%v", crop)
+	tall := detailedImage(image.Rect(0, 0, 100, 400), image.Rect(0, 120, 100, 150))
+	crop = smartCrop(tall, 200, 100)
+	assert.Equal(t, image.Pt(100, 50), crop.Size())
+	assert.True(t, crop.Min.Y <= 120 && crop.Max.Y >= 150, "%v", crop)
+}
+
+func TestRender(t *testing.T) {
+	img := detailedImage(image.Rect(0, 0, 120, 40), image.Rect(0, 0, 20, 40))
+	for _, mode := range []string{renderBlur, renderSmartCrop, renderCenter} {
+		assert.Equal(t, image.Rect(0, 0, 64, 36), render(img, mode, 64, 36).Bounds(), mode)
+	}
+}
+
+func writeTestImage(t *testing.T, file string, img image.Image) {
+	w, err := os.Create(file)
+	assert.NoError(t, err)
+	defer w.Close()
+	assert.NoError(t, png.Encode(w, img))
+}
+
+func TestRendition(t *testing.T) {
+	testHome := setupTestHome(t)
+	defer cleanUp(t, testHome)
+	src := filepath.Join(testHome, "apod-img-2014-09-21")
+	writeTestImage(t, src, detailedImage(image.Rect(0, 0, 120, 40), image.Rect(0, 0, 120, 40)))
+
+	file, err := rendition(src, renderCenter, 64, 36, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(renditionDir(), "apod-img-2014-09-21-center-64x36.jpg"), file)
+	img, err := decodeImage(file)
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 64, 36), img.Bounds())
+
+	old := time.Now().Add(-renditionMaxAge - time.Hour)
+	assert.NoError(t, os.Chtimes(src, old, old))
+	assert.NoError(t, os.Chtimes(file, old, old))
+	again, err := rendition(src, renderCenter, 64, 36, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, file, again)
+	info, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.True(t, time.Since(info.ModTime()) < time.Minute, "a used rendition is kept")
+
+	assert.NoError(t, os.Chtimes(file, old, old))
+	_, err = rendition(src, renderBlur, 32, 32, nil)
+	assert.NoError(t, err)
+	present, err := exists(file)
+	assert.NoError(t, err)
+	assert.False(t, present, "an unused rendition is removed")
+
+	_, err = rendition(src, "sharpen", 64, 36, nil)
+	assert.Equal(t, "Unknown render mode: sharpen", err.Error())
+}
+
+func TestScreenFile(t *testing.T) {
+	f, _, testHome := frontendForTestMonitors(t, monitorsSame)
+	defer cleanUp(t, testHome)
+	w := apodOn("140921")
+	writeTestImage(t, f.Config.fileName(w), detailedImage(image.Rect(0, 0, 120, 40), image.Rect(0, 0, 120, 40)))
+	monitors, err := listMonitors(f.Config.Setter, f.run)
+	assert.NoError(t, err)
+	assert.Equal(t, f.Config.fileName(w), f.screenFile(w, f.screenSize(monitors)))
+	f.Config.Render = renderSmartCrop
+	assert.Equal(t, image.Pt(2560, 1440), f.screenSize(monitors))
+	assert.Equal(t, filepath.Join(renditionDir(), "apod-img-2014-09-21-smartcrop-2560x1440.jpg"), f.screenFile(w, f.screenSize(monitors)))
+	f.Config.Monitors = monitorsSpan
+	assert.Equal(t, image.Pt(4480, 1440), f.screenSize(monitors))
+	assert.Equal(t, f.Config.fileName(apodOn("140920")), f.screenFile(apodOn("140920"), f.screenSize(monitors)), "an empty image is shown as is")
+}
@@ -0,0 +1,208 @@
+package apod
+
+import (
+	"fmt"
+	"image"
+	"regexp"
+	"strings"
+)
+
+// The orientations a rule can select.
+const (
+	orientationLandscape = "landscape"
+	orientationPortrait  = "portrait"
+)
+
+// rule selects images by what the loader recorded of them when they were
+// downloaded. The conditions that are set must all hold for an image to be
+// selected.
+type rule struct {
+	// Exclude leaves the images selected out of the rotation, instead of
+	// keeping only them.
+	Exclude bool
+	// Keywords selects the images with one of the keywords.
+	Keywords []string
+	// Title and Credit are regular expressions the title and the credit must match.
+	Title  string
+	Credit string
+	// From and To select the images of those dates and of the dates between.
+	From, To ADate
+	// MinWidth and MinHeight are the least size in pixels.
+	MinWidth, MinHeight int
+	// Orientation is landscape or portrait.
+	Orientation string
+	// Copyrighted selects the images under copyright.
+	Copyrighted bool
+}
+
+// compiledRule is a rule with its regular expressions compiled.
+type compiledRule struct {
+	rule
+	title, credit *regexp.Regexp
+}
+
+// compileRules checks the rules and compiles their regular expressions.
+func compileRules(rules []rule) ([]compiledRule, error) {
+	var compiled []compiledRule
+	for i, r := range rules {
+		c := compiledRule{rule: r}
+		var err error
+		if r.Title != "" {
+			if c.title, err = regexp.Compile(r.Title); err != nil {
+				return nil, fmt.Errorf("Invalid title pattern in rule %d: %v", i+1, err)
+			}
+		}
+		if r.Credit != "" {
+			if c.credit, err = regexp.Compile(r.Credit); err != nil {
+				return nil, fmt.Errorf("Invalid credit pattern in rule %d: %v", i+1, err)
+			}
+		}
+		switch r.Orientation {
+		case "", orientationLandscape, orientationPortrait:
+		default:
+			return nil, fmt.Errorf("Unknown orientation in rule %d: %s", i+1, r.Orientation)
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// needsSize reports whether the rule looks at the size of the images.
+func (r *compiledRule) needsSize() bool {
+	return r.MinWidth > 0 || r.MinHeight > 0 || r.Orientation != ""
+}
+
+// selects reports whether the rule selects the image of w, described by e.
+func (r *compiledRule) selects(w Wallpaper, e *Entry) bool {
+	if len(r.Keywords) > 0 && !hasKeyword(e.Keywords, r.Keywords) {
+		return false
+	}
+	if r.title != nil && !r.title.MatchString(e.Title) {
+		return false
+	}
+	if r.credit != nil && !r.credit.MatchString(e.Credit) {
+		return false
+	}
+	if !r.From.IsZero() && w.Date.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && r.To.Before(w.Date) {
+		return false
+	}
+	if e.Width < r.MinWidth || e.Height < r.MinHeight {
+		return false
+	}
+	switch r.Orientation {
+	case orientationLandscape:
+		if e.Width <= e.Height {
+			return false
+		}
+	case orientationPortrait:
+		if e.Height <= e.Width {
+			return false
+		}
+	}
+	if r.Copyrighted && !e.Copyright {
+		return false
+	}
+	return true
+}
+
+// hasKeyword reports whether keywords holds one of wanted, ignoring case.
+func hasKeyword(keywords, wanted []string) bool {
+	for _, k := range keywords {
+		for _, w := range wanted {
+			if strings.EqualFold(strings.TrimSpace(k), w) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// passes reports whether the image of w passes all rules, and if not, why.
+func passes(rules []compiledRule, w Wallpaper, e *Entry) (bool, string) {
+	for i, r := range rules {
+		selected := r.selects(w, e)
+		if r.Exclude && selected {
+			return false, fmt.Sprintf("excluded by rule %d", i+1)
+		}
+		if !r.Exclude && !selected {
+			return false, fmt.Sprintf("not selected by rule %d", i+1)
+		}
+	}
+	return true, ""
+}
+
+// attributes returns what is known of the image of w. The size of images
+// downloaded before it was recorded is read from the file, if needed.
+func (f *Frontend) attributes(w Wallpaper, needSize bool) *Entry {
+	e, err := f.storage.Entry(w)
+	if err != nil {
+		e = &Entry{Source: w.Source, Date: w.Date}
+	}
+	if needSize && (e.Width == 0 || e.Height == 0) {
+		size, err := imageSize(f.Config.fileName(w))
+		if err != nil {
+			size = image.Point{}
+		}
+		e.Width, e.Height = size.X, size.Y
+	}
+	return e
+}
+
+// applyRules leaves the wallpapers of ws that pass the rules of the
+// configuration, in the order of ws.
+func (f *Frontend) applyRules(ws []Wallpaper) ([]Wallpaper, error) {
+	if len(f.Config.Rules) == 0 {
+		return ws, nil
+	}
+	rules, err := compileRules(f.Config.Rules)
+	if err != nil {
+		return nil, err
+	}
+	needSize := false
+	for _, r := range rules {
+		needSize = needSize || r.needsSize()
+	}
+	var passed []Wallpaper
+	for _, w := range ws {
+		if ok, _ := passes(rules, w, f.attributes(w, needSize)); ok {
+			passed = append(passed, w)
+		}
+	}
+	return passed, nil
+}
+
+// DryRun lists every downloaded wallpaper with whether it passes the rules of
+// the configuration, and why not.
+func (f *Frontend) DryRun() (string, error) {
+	rules, err := compileRules(f.Config.Rules)
+	if err != nil {
+		return "", err
+	}
+	ws, err := f.storage.DownloadedWallpapers()
+	if err != nil {
+		return "", err
+	}
+	list := ""
+	passed := 0
+	for _, w := range ws {
+		e := f.attributes(w, true)
+		ok, why := passes(rules, w, e)
+		line := "pass " + w.String()
+		if !ok {
+			line = "fail " + w.String()
+		}
+		if e.Title != "" {
+			line += " " + e.Title
+		}
+		if ok {
+			passed++
+		} else {
+			line += " (" + why + ")"
+		}
+		list += line + "\n"
+	}
+	return list + fmt.Sprintf("%d of %d wallpapers pass the rules\n", passed, len(ws)), nil
+}
@@ -0,0 +1,82 @@
+package apod
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileRules(t *testing.T) {
+	_, err := compileRules([]rule{{}, {Title: "("}})
+	assert.Equal(t, "Invalid title pattern in rule 2: error parsing regexp: missing closing ): `(`", err.Error())
+	_, err = compileRules([]rule{{Orientation: "square"}})
+	assert.Equal(t, "Unknown orientation in rule 1: square", err.Error())
+}
+
+func TestPasses(t *testing.T) {
+	w := apodOn("140924")
+	e := &Entry{Title: "The Lagoon Nebula", Keywords: []string{"M8", " Lagoon Nebula"}, Credit: "Remus Chua", Copyright: true, Width: 2500, Height: 1600}
+	for _, c := range []struct {
+		rules []rule
+		ok    bool
+		why   string
+	}{
+		{nil, true, ""},
+		{[]rule{{Keywords: []string{"lagoon nebula"}}, {Title: "Nebula$"}}, true, ""},
+		{[]rule{{Keywords: []string{"comet"}}}, false, "not selected by rule 1"},
+		{[]rule{{Orientation: orientationLandscape, MinWidth: 1920}}, true, ""},
+		{[]rule{{Orientation: orientationPortrait}}, false, "not selected by rule 1"},
+		{[]rule{{MinHeight: 2000}}, false, "not selected by rule 1"},
+		{[]rule{{From: adate("140901"), To: adate("140924")}}, true, ""},
+		{[]rule{{To: adate("140923")}}, false, "not selected by rule 1"},
+		{[]rule{{Credit: "Chua"}, {Exclude: true, Copyrighted: true}}, false, "excluded by rule 2"},
+		{[]rule{{Exclude: true, Title: "Comet"}}, true, ""},
+	} {
+		rules, err := compileRules(c.rules)
+		assert.NoError(t, err)
+		ok, why := passes(rules, w, e)
+		assert.Equal(t, c.ok, ok, "%+v", c.rules)
+		assert.Equal(t, c.why, why, "%+v", c.rules)
+	}
+}
+
+func rulesForTest(t *testing.T) (*Frontend, string) {
+	f, testHome := commandForTest(t)
+	writeTestImage(t, f.Config.fileName(apodOn("140119")), image.NewRGBA(image.Rect(0, 0, 160, 90)))
+	writeTestImage(t, f.Config.fileName(apodOn("140120")), image.NewRGBA(image.Rect(0, 0, 90, 160)))
+	writeTestImage(t, f.Config.fileName(apodOn("140121")), image.NewRGBA(image.Rect(0, 0, 160, 90)))
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: apodOn("140119").Date, Title: "Comet Lovejoy"}))
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: apodOn("140121").Date, Title: "M8", Copyright: true}))
+	return f, testHome
+}
+
+func TestRulesRestrictJump(t *testing.T) {
+	f, testHome := rulesForTest(t)
+	defer cleanUp(t, testHome)
+	f.Config.Rules = []rule{{Orientation: orientationLandscape}, {Exclude: true, Title: "(?i)comet"}}
+	ws, err := f.eligible()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140121")}, ws)
+	assert.NoError(t, f.Jump(1))
+	assertShowing(t, f, "apod:2014-01-21 fit")
+	assert.Equal(t, errBeginReached, f.Jump(-1))
+
+	f.Config.Rules = []rule{{Title: "("}}
+	_, err = f.eligible()
+	assert.Error(t, err)
+}
+
+func TestDryRun(t *testing.T) {
+	f, testHome := rulesForTest(t)
+	defer cleanUp(t, testHome)
+	f.Config.Rules = []rule{{Orientation: orientationLandscape}, {Exclude: true, Copyrighted: true}}
+	list, err := f.DryRun()
+	assert.NoError(t, err)
+	assert.Equal(t, "pass apod:2014-01-19 Comet Lovejoy\n"+
+		"fail apod:2014-01-20 (not selected by rule 1)\n"+
+		"fail apod:2014-01-21 M8 (excluded by rule 2)\n"+
+		"1 of 3 wallpapers pass the rules\n", list)
+	assert.NoError(t, f.Command(context.Background(), []string{"rules", "-dry-run"}))
+}
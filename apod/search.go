@@ -0,0 +1,427 @@
+package apod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	indexBasename     = "apod-search-index.json"
+	indexLockBasename = "apod-search-index.lock"
+	rotationBasename  = "search-rotation"
+)
+
+// The weights of a word in the parts of an entry.
+const (
+	titleWeight       = 5
+	keywordWeight     = 3
+	explanationWeight = 1
+)
+
+// stopWords are too common to search for.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "in": true, "is": true,
+	"it": true, "its": true, "of": true, "on": true, "or": true, "that": true,
+	"the": true, "this": true, "to": true, "was": true, "with": true,
+}
+
+func (c *config) indexFile() string {
+	return filepath.Join(c.WallpaperDir, indexBasename)
+}
+
+// withIndexLock runs fn while holding a lock on the index, so that the
+// downloads of concurrent goroutines and invocations do not lose each
+// other's updates. It is apart from the lock of withLock, as the rotation
+// reads the index while holding that.
+func (c *config) withIndexLock(fn func() error) error {
+	return withFileLock(filepath.Join(c.WallpaperDir, indexLockBasename), fn)
+}
+
+func rotationFile() string {
+	return filepath.Join(configDir(), rotationBasename)
+}
+
+// searchIndex is an inverted index of the titles, keywords and explanations
+// of the wallpapers.
+type searchIndex struct {
+	// Terms maps each word to the wallpapers it occurs in, with its weight there.
+	Terms map[string]map[string]int
+	// Indexed holds the wallpapers indexed.
+	Indexed map[string]bool
+}
+
+func (c *config) readIndex() (*searchIndex, error) {
+	idx := &searchIndex{Terms: make(map[string]map[string]int), Indexed: make(map[string]bool)}
+	bs, err := ioutil.ReadFile(c.indexFile())
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return idx, json.Unmarshal(bs, idx)
+}
+
+func (c *config) storeIndex(idx *searchIndex) error {
+	bs, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.indexFile(), bs, 0644)
+}
+
+// words splits text into lower case words, leaving out the stop words.
+func words(text string) []string {
+	var ws []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if !stopWords[w] {
+			ws = append(ws, w)
+		}
+	}
+	return ws
+}
+
+// add indexes e, replacing what was indexed for its wallpaper before.
+func (idx *searchIndex) add(e *Entry) {
+	w := e.Wallpaper().String()
+	if idx.Indexed[w] {
+		for term, postings := range idx.Terms {
+			delete(postings, w)
+			if len(postings) == 0 {
+				delete(idx.Terms, term)
+			}
+		}
+	}
+	weigh := func(text string, weight int) {
+		for _, term := range words(text) {
+			if idx.Terms[term] == nil {
+				idx.Terms[term] = make(map[string]int)
+			}
+			idx.Terms[term][w] += weight
+		}
+	}
+	weigh(e.Title, titleWeight)
+	weigh(strings.Join(e.Keywords, " "), keywordWeight)
+	weigh(e.Explanation, explanationWeight)
+	idx.Indexed[w] = true
+}
+
+// hit is a wallpaper found, with the number of words of the query it
+// matches and its score.
+type hit struct {
+	w       Wallpaper
+	matched int
+	score   int
+}
+
+// search ranks the wallpapers of ws by the words of query, the ones matching
+// most words first, then the ones scoring highest, then the newest. A word
+// of the query also matches the longer words it begins, nebula matches
+// nebulae. Wallpapers matching no word are left out.
+func (idx *searchIndex) search(query string, ws []Wallpaper) []hit {
+	matched, scores := idx.scores(query)
+	var hits []hit
+	for _, w := range ws {
+		if n := matched[w.String()]; n > 0 {
+			hits = append(hits, hit{w: w, matched: n, score: scores[w.String()]})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].matched != hits[j].matched {
+			return hits[i].matched > hits[j].matched
+		}
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[j].w.before(hits[i].w)
+	})
+	return hits
+}
+
+// scores returns, by wallpaper, the number of words of query it matches and
+// its score. The terms matching a word are looked up once, and their
+// postings added up.
+func (idx *searchIndex) scores(query string) (map[string]int, map[string]int) {
+	matched := make(map[string]int)
+	scores := make(map[string]int)
+	for _, word := range words(query) {
+		found := make(map[string]int)
+		for term, postings := range idx.Terms {
+			if !strings.HasPrefix(term, word) {
+				continue
+			}
+			for w, weight := range postings {
+				found[w] += weight
+			}
+		}
+		for w, score := range found {
+			matched[w]++
+			scores[w] += score
+		}
+	}
+	return matched, scores
+}
+
+// index adds es to the index, failing to do so does not fail the download.
+func (l *Loader) index(es ...*Entry) {
+	if len(es) == 0 {
+		return
+	}
+	err := l.Config.withIndexLock(func() error {
+		idx, err := l.Config.readIndex()
+		if err != nil {
+			return err
+		}
+		for _, e := range es {
+			idx.add(e)
+		}
+		return l.Config.storeIndex(idx)
+	})
+	if err != nil {
+		l.Printf("Could not index %d wallpapers, because: %v\n", len(es), err)
+	}
+}
+
+// indexBatch collects the entries stored during a run, to be indexed at once.
+type indexBatch struct {
+	mu      sync.Mutex
+	entries []*Entry
+}
+
+// batched returns a copy of l that collects the entries it stores in a new
+// batch, instead of indexing every one of them.
+func (l *Loader) batched() *Loader {
+	b := *l
+	b.batch = new(indexBatch)
+	return &b
+}
+
+// flush indexes the entries collected in the batch of l.
+func (l *Loader) flush() {
+	l.batch.mu.Lock()
+	es := l.batch.entries
+	l.batch.entries = nil
+	l.batch.mu.Unlock()
+	l.index(es...)
+}
+
+// storeEntry stores the metadata of an image and indexes it, or adds it to
+// the batch if l has one.
+func (l *Loader) storeEntry(e *Entry) error {
+	if err := l.Config.writeEntry(e); err != nil {
+		return err
+	}
+	if l.batch != nil {
+		l.batch.mu.Lock()
+		l.batch.entries = append(l.batch.entries, e)
+		l.batch.mu.Unlock()
+		return nil
+	}
+	l.index(e)
+	return nil
+}
+
+// addStored adds the wallpapers of ws that are not in idx yet by their stored
+// metadata. It returns the ones left out for lack of metadata, and whether
+// any was added.
+func (f *Frontend) addStored(idx *searchIndex, ws []Wallpaper) ([]Wallpaper, bool) {
+	var (
+		unindexed []Wallpaper
+		added     bool
+	)
+	for _, w := range ws {
+		if idx.Indexed[w.String()] {
+			continue
+		}
+		e, err := f.storage.Entry(w)
+		if err != nil {
+			unindexed = append(unindexed, w)
+			continue
+		}
+		e.Source, e.Date = w.Source, w.Date
+		idx.add(e)
+		added = true
+	}
+	return unindexed, added
+}
+
+// buildIndex indexes the downloaded wallpapers that are not indexed yet,
+// fetching the pages of the ones without metadata, and returns the index.
+func (f *Frontend) buildIndex(ctx context.Context) (*searchIndex, error) {
+	ws, err := f.storage.DownloadedWallpapers()
+	if err != nil {
+		return nil, err
+	}
+	idx, err := f.Config.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	var missing []Wallpaper
+	for _, w := range ws {
+		if !idx.Indexed[w.String()] {
+			missing = append(missing, w)
+		}
+	}
+	if len(missing) == 0 {
+		return idx, nil
+	}
+	f.Log.Printf("Indexing %d wallpapers\n", len(missing))
+	// the entries completed are indexed below, with the ones present already
+	l := f.loader.batched()
+	for _, w := range missing {
+		if src, err := source(l.Sources, w.Source); err == nil {
+			l.completeEntry(ctx, src, w)
+		}
+	}
+	err = f.Config.withIndexLock(func() error {
+		if idx, err = f.Config.readIndex(); err != nil {
+			return err
+		}
+		f.addStored(idx, missing)
+		return f.Config.storeIndex(idx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+// Search returns the downloaded wallpapers matching query, the best first.
+func (f *Frontend) Search(ctx context.Context, query string) ([]Wallpaper, error) {
+	idx, err := f.buildIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := f.storage.DownloadedWallpapers()
+	if err != nil {
+		return nil, err
+	}
+	var found []Wallpaper
+	for _, h := range idx.search(query, ws) {
+		found = append(found, h.w)
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("Nothing found for %q", query)
+	}
+	return found, nil
+}
+
+// describe lists the wallpapers with their titles.
+func (f *Frontend) describe(ws []Wallpaper) string {
+	list := ""
+	for _, w := range ws {
+		line := w.String()
+		if e, err := f.storage.Entry(w); err == nil && e.Title != "" {
+			line += " " + e.Title
+		}
+		list += line + "\n"
+	}
+	return list
+}
+
+// readRotation returns the query the rotation is restricted to, if any.
+func readRotation() (string, error) {
+	bs, err := ioutil.ReadFile(rotationFile())
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(bs)), err
+}
+
+// SetRotation restricts jump, random and the daemon to the wallpapers
+// matching query, or lifts the restriction if query is empty.
+func (f *Frontend) SetRotation(ctx context.Context, query string) error {
+	if strings.TrimSpace(query) == "" {
+		err := os.Remove(rotationFile())
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if _, err := f.Search(ctx, query); err != nil {
+		return err
+	}
+	return writeFileAtomic(rotationFile(), []byte(query+"\n"), 0644)
+}
+
+// rotationMatches caches the wallpapers matching the query of the rotation,
+// until the query or the index changes, or wallpapers not seen before come.
+type rotationMatches struct {
+	query string
+	index os.FileInfo
+	found map[string]int
+	// seen holds the wallpapers the matches were searched for
+	seen map[string]bool
+}
+
+// covers tells whether all wallpapers of ws were seen when m was searched.
+func (m *rotationMatches) covers(ws []Wallpaper) bool {
+	for _, w := range ws {
+		if !m.seen[w.String()] {
+			return false
+		}
+	}
+	return true
+}
+
+// inRotation leaves the wallpapers of ws that match the query the rotation
+// is restricted to, if any, in the order of ws. The wallpapers of ws missing
+// from the index are indexed by their stored metadata first, the ones
+// without metadata are reported, as they cannot be matched until a search
+// fetches their pages.
+func (f *Frontend) inRotation(ws []Wallpaper) ([]Wallpaper, error) {
+	query, err := readRotation()
+	if err != nil || query == "" {
+		return ws, err
+	}
+	err = f.Config.withIndexLock(func() error {
+		fi, _ := os.Stat(f.Config.indexFile())
+		if m := f.rotation; m != nil && m.query == query && unchanged(fi, m.index) && m.covers(ws) {
+			return nil
+		}
+		idx, err := f.Config.readIndex()
+		if err != nil {
+			return err
+		}
+		unindexed, added := f.addStored(idx, ws)
+		if added {
+			if err := f.Config.storeIndex(idx); err != nil {
+				return err
+			}
+			fi, _ = os.Stat(f.Config.indexFile())
+		}
+		if len(unindexed) > 0 {
+			f.Log.Printf("Left %d wallpapers without metadata out of the rotation, run apod-bg search to index them: %v\n",
+				len(unindexed), unindexed)
+		}
+		seen := make(map[string]bool)
+		for _, w := range ws {
+			seen[w.String()] = true
+		}
+		found, _ := idx.scores(query)
+		f.rotation = &rotationMatches{query: query, index: fi, found: found, seen: seen}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	found := f.rotation.found
+	var in []Wallpaper
+	for _, w := range ws {
+		if found[w.String()] > 0 {
+			in = append(in, w)
+		}
+	}
+	return in, nil
+}
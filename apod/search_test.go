@@ -0,0 +1,181 @@
+package apod
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWords(t *testing.T) {
+	assert.Equal(t, []string{"lagoon", "nebula", "m8", "stars"}, words("The Lagoon Nebula (M8) in Stars"))
+}
+
+func TestSearchIndex(t *testing.T) {
+	idx := &searchIndex{Terms: make(map[string]map[string]int), Indexed: make(map[string]bool)}
+	lagoon, orion, saturn := apodOn("140119"), apodOn("140120"), apodOn("140121")
+	idx.add(&Entry{Source: apodName, Date: lagoon.Date, Title: "The Lagoon Nebula", Explanation: "Stars are born here."})
+	idx.add(&Entry{Source: apodName, Date: orion.Date, Title: "Orion", Keywords: []string{"nebulae"}, Explanation: "Hubble took it."})
+	idx.add(&Entry{Source: apodName, Date: saturn.Date, Title: "Saturn", Explanation: "Hubble saw the rings."})
+	ws := []Wallpaper{lagoon, orion, saturn}
+
+	var found []Wallpaper
+	for _, h := range idx.search("nebula hubble", ws) {
+		found = append(found, h.w)
+	}
+	assert.Equal(t, []Wallpaper{orion, lagoon, saturn}, found)
+	assert.Equal(t, 0, len(idx.search("jupiter", ws)))
+	assert.Equal(t, 1, len(idx.search("nebula", ws[:1])), "only the wallpapers given are found")
+
+	idx.add(&Entry{Source: apodName, Date: lagoon.Date, Title: "M8"})
+	assert.Equal(t, 1, len(idx.search("nebula", ws)), "indexing again replaces the entry")
+}
+
+func TestStoreEntryIndexes(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.loader.storeEntry(&Entry{Source: apodName, Date: apodOn("140119").Date, Title: "Lagoon Nebula"}))
+	idx, err := f.Config.readIndex()
+	assert.NoError(t, err)
+	assert.True(t, idx.Indexed["apod:2014-01-19"])
+	assert.Equal(t, map[string]int{"apod:2014-01-19": titleWeight}, idx.Terms["lagoon"])
+}
+
+func TestSearchBuildsIndex(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, f.Config, "140921", "140922", "140923")
+	found, err := f.Search(context.Background(), "saturn equinox")
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140921"), apodOn("140922")}, found)
+	idx, err := f.Config.readIndex()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(idx.Indexed))
+	assert.Equal(t, "apod:2014-09-21 Saturn at Equinox\n", f.describe(found[:1]))
+
+	_, err = f.Search(context.Background(), "jupiter")
+	assert.Equal(t, `Nothing found for "jupiter"`, err.Error())
+}
+
+func TestSearchRotation(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	for code, title := range map[string]string{"140119": "Lagoon Nebula", "140120": "Saturn", "140121": "Orion Nebula"} {
+		assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: apodOn(code).Date, Title: title}))
+	}
+	assert.NoError(t, f.Command(context.Background(), []string{"search", "-rotate", "nebula"}))
+	ws, err := f.eligible()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140119"), apodOn("140121")}, ws)
+	assert.NoError(t, f.Jump(-1))
+	assertShowing(t, f, "apod:2014-01-19 fit")
+	assert.NoError(t, f.Jump(1))
+	assertShowing(t, f, "apod:2014-01-21 fit")
+
+	assert.NoError(t, f.Command(context.Background(), []string{"search", "-rotate", ""}))
+	ws, err = f.eligible()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(ws))
+	assert.Error(t, f.Command(context.Background(), []string{"search", "-rotate", "jupiter"}))
+	query, err := readRotation()
+	assert.NoError(t, err)
+	assert.Equal(t, "", query)
+}
+
+func TestCommandSearchShow(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: apodOn("140121").Date, Title: "Saturn"}))
+	assert.NoError(t, f.Command(context.Background(), []string{"search", "-show", "saturn"}))
+	assertShowing(t, f, "apod:2014-01-21 fit")
+}
+
+func TestRotationCached(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: apodOn("140119").Date, Title: "Lagoon Nebula"}))
+	assert.NoError(t, f.SetRotation(context.Background(), "nebula"))
+	ws, err := f.eligible()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140119")}, ws)
+	cached := f.rotation
+	_, err = f.eligible()
+	assert.NoError(t, err)
+	assert.True(t, cached == f.rotation, "the matches are not searched again")
+
+	f.loader.index(&Entry{Source: apodName, Date: apodOn("140121").Date, Title: "Orion Nebula"})
+	ws, err = f.eligible()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140119"), apodOn("140121")}, ws, "a change of the index is seen")
+}
+
+func TestRotationIndexesStored(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: apodOn("140119").Date, Title: "Lagoon Nebula"}))
+	assert.NoError(t, f.SetRotation(context.Background(), "nebula"))
+	// stored without being indexed, as by a download whose indexing failed
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: apodName, Date: apodOn("140121").Date, Title: "Orion Nebula"}))
+	var logged bytes.Buffer
+	f.Log = log.New(&logged, "", 0)
+	ws, err := f.eligible()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140119"), apodOn("140121")}, ws)
+	idx, err := f.Config.readIndex()
+	assert.NoError(t, err)
+	assert.True(t, idx.Indexed["apod:2014-01-21"])
+	assert.Contains(t, logged.String(), "Left 1 wallpapers without metadata out of the rotation")
+	assert.Contains(t, logged.String(), "apod:2014-01-20")
+}
+
+func TestLoadPeriodIndexesOnce(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	l := f.loader.batched()
+	assert.NoError(t, l.storeEntry(&Entry{Source: apodName, Date: apodOn("140119").Date, Title: "Lagoon Nebula"}))
+	present, err := exists(f.Config.indexFile())
+	assert.NoError(t, err)
+	assert.False(t, present, "a batch is not indexed before it is flushed")
+	l.flush()
+	idx, err := f.Config.readIndex()
+	assert.NoError(t, err)
+	assert.True(t, idx.Indexed["apod:2014-01-19"])
+
+	_, err = f.loader.LoadPeriod(context.Background(), adate("140922"), 2)
+	assert.NoError(t, err)
+	idx, err = f.Config.readIndex()
+	assert.NoError(t, err)
+	assert.True(t, idx.Indexed["apod:2014-09-21"])
+	assert.Nil(t, f.loader.batch, "the loader itself keeps indexing one by one")
+}
+
+func TestIndexWaitsForLock(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	locked, release := make(chan struct{}), make(chan struct{})
+	go f.Config.withIndexLock(func() error {
+		close(locked)
+		<-release
+		// another invocation indexes meanwhile
+		idx, err := f.Config.readIndex()
+		assert.NoError(t, err)
+		idx.add(&Entry{Source: apodName, Date: apodOn("140119").Date, Title: "Lagoon Nebula"})
+		return f.Config.storeIndex(idx)
+	})
+	<-locked
+	indexed := make(chan struct{})
+	go func() {
+		f.loader.index(&Entry{Source: apodName, Date: apodOn("140121").Date, Title: "Orion Nebula"})
+		close(indexed)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	<-indexed
+	idx, err := f.Config.readIndex()
+	assert.NoError(t, err)
+	assert.True(t, idx.Indexed["apod:2014-01-19"], "the update of the other invocation is kept")
+	assert.True(t, idx.Indexed["apod:2014-01-21"])
+}
@@ -0,0 +1,293 @@
+package apod
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	socketBasename = "apod-bg.sock"
+	replyOK        = "ok"
+	replyError     = "error"
+)
+
+// socketPath returns where the server listens, in $XDG_RUNTIME_DIR or the
+// config dir if that is not set.
+func socketPath() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = configDir()
+	}
+	return filepath.Join(dir, socketBasename)
+}
+
+// Server keeps the configuration, state and wallpaper listing in memory and
+// handles the requests of other apod-bg invocations one at a time. The
+// configuration, the state and the listing are reread when their files change.
+//
+// A request is a single line: next, prev, jump N, random, mode, info, status
+// or fetch [flags] N. The reply is ok or error on the first line, followed by a message.
+type Server struct {
+	*Frontend
+	mu       sync.Mutex
+	fetching sync.Mutex
+	// config is held for reading while the configuration is used, and for
+	// writing while it is reloaded
+	config     sync.RWMutex
+	configRead os.FileInfo
+	listener   net.Listener
+	// ctx ends the requests in progress on Close
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// NewServer constructs a server for f, keeping its state in memory.
+func NewServer(f *Frontend) *Server {
+	f.keep = true
+	f.storage.cache = true
+	ctx, cancel := context.WithCancel(context.Background())
+	read, _ := os.Stat(configFile())
+	return &Server{Frontend: f, configRead: read, ctx: ctx, cancel: cancel}
+}
+
+// reloadConfig loads the configuration again if its file changed since it
+// was loaded. A configuration that fails to load is not taken.
+func (s *Server) reloadConfig() error {
+	read, err := os.Stat(configFile())
+	s.config.RLock()
+	same := err == nil && unchanged(read, s.configRead)
+	s.config.RUnlock()
+	if same {
+		return nil
+	}
+	s.config.Lock()
+	defer s.config.Unlock()
+	if read, err = os.Stat(configFile()); err == nil && unchanged(read, s.configRead) {
+		return nil
+	}
+	// the configuration is replaced, not changed, as fetches in progress use it
+	cfg, sources := s.Config, s.loader.Sources
+	s.Config = new(config)
+	if err := s.Loadconfig(); err != nil {
+		s.Config, s.storage.Config, s.loader.Config, s.loader.Sources = cfg, cfg, cfg, sources
+		return fmt.Errorf("Could not reload the configuration, because: %v", err)
+	}
+	s.storage.wallpapers = nil
+	s.configRead = read
+	return nil
+}
+
+// Listen listens on the Unix socket at path, replacing a stale socket.
+func (s *Server) Listen(path string) error {
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("A server is already listening on %s", path)
+	}
+	os.Remove(path)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	s.listener = l
+	return nil
+}
+
+// Serve handles connections until Close is called.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if s.ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close stops the server and removes its socket.
+func (s *Server) Close() error {
+	s.cancel()
+	return s.listener.Close()
+}
+
+// handle executes the request on conn. The client sends nothing after its
+// request, so the request is ended when reading from conn ends: when the
+// client hangs up.
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	request, err := r.ReadString('\n')
+	if err != nil {
+		s.Log.Printf("Could not read the request, because: %v\n", err)
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	go func() {
+		io.Copy(ioutil.Discard, r)
+		cancel()
+	}()
+	reply, err := s.DoContext(ctx, strings.TrimSpace(request))
+	if err != nil {
+		fmt.Fprintf(conn, "%s\n%v", replyError, err)
+		return
+	}
+	fmt.Fprintf(conn, "%s\n%s", replyOK, reply)
+}
+
+// Do executes a request, one at a time and holding the lock against other
+// invocations. A fetch runs beside the other requests, as it only adds images.
+func (s *Server) Do(request string) (string, error) {
+	return s.DoContext(s.ctx, request)
+}
+
+// DoContext is Do, ending a fetch when ctx is done.
+func (s *Server) DoContext(ctx context.Context, request string) (reply string, err error) {
+	if fields := strings.Fields(request); len(fields) > 0 && fields[0] == "fetch" {
+		return s.fetchRequest(ctx, fields[1:])
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err = withLock(func() error {
+		if err := s.reloadConfig(); err != nil {
+			return err
+		}
+		s.config.RLock()
+		defer s.config.RUnlock()
+		reply, err = s.do(request)
+		return err
+	})
+	return reply, err
+}
+
+func (s *Server) do(request string) (string, error) {
+	fields := strings.Fields(request)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("Empty request")
+	}
+	command, args := fields[0], fields[1:]
+	switch {
+	case command == "next" && len(args) <= 1:
+		return s.after(s.JumpOutput(optArg(args, 0), 1))
+	case command == "prev" && len(args) <= 1:
+		return s.after(s.JumpOutput(optArg(args, 0), -1))
+	case command == "jump" && (len(args) == 1 || len(args) == 2):
+		n, err := strconv.Atoi(args[0])
+		if err != nil {
+			return "", fmt.Errorf("Invalid jump: %s", args[0])
+		}
+		return s.after(s.JumpOutput(optArg(args, 1), n))
+	case command == "back" && len(args) == 0:
+		return s.after(s.Back())
+	case command == "forward" && len(args) == 0:
+		return s.after(s.Forward())
+	case command == "history" && len(args) == 0:
+		return s.History()
+	case command == "random" && len(args) == 0:
+		return s.after(s.RandomArchive())
+	case command == "mode" && len(args) == 0:
+		return s.ToggleViewMode()
+	case command == "info" && len(args) <= 1:
+		return s.after(s.OpenAPODOnBackground(optArg(args, 0)))
+	case command == "status" && len(args) == 0:
+		return s.Status()
+	case command == "fav" && len(args) <= 1:
+		return s.mark(optArg(args, 0), markFavorite)
+	case command == "unfav" && len(args) <= 1:
+		return s.mark(optArg(args, 0), "")
+	case command == "ban" && len(args) <= 1:
+		return s.mark(optArg(args, 0), markBanned)
+	case command == "favorites" && len(args) == 0:
+		return s.Favorites()
+	}
+	return "", fmt.Errorf("Unknown request: %s", request)
+}
+
+// fetchRequest downloads the images of the last days, taking the flags of
+// the fetch command. It does not hold the lock, so that the other requests
+// need not wait for it, but one fetch waits for the other. It waits for the
+// request in progress only to reload a changed configuration. The fetch ends
+// when ctx is done.
+func (s *Server) fetchRequest(ctx context.Context, args []string) (string, error) {
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	o := fetchFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return "", fmt.Errorf("Invalid fetch request: %v", err)
+	}
+	if fs.NArg() != 1 {
+		return "", fmt.Errorf("Unknown request: fetch %s", strings.Join(args, " "))
+	}
+	s.fetching.Lock()
+	defer s.fetching.Unlock()
+	if err := s.reloadConfig(); err != nil {
+		return "", err
+	}
+	s.config.RLock()
+	l := *s.loader
+	s.config.RUnlock()
+	report, err := s.fetch(ctx, l, o, fs.Arg(0))
+	if report == nil {
+		return "", err
+	}
+	return report.String(), err
+}
+
+// mark marks the wallpaper shown and describes what was marked.
+func (s *Server) mark(output, mark string) (string, error) {
+	w, err := s.Mark(output, mark)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s is %s now", w, markName(mark)), nil
+}
+
+// after returns the status after a command that changes the wallpaper.
+func (s *Server) after(err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return s.Status()
+}
+
+// forward sends the request to the server at path. It reports whether a server
+// handled the request, and returns its reply.
+func forward(path, request string) (string, bool, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return "", false, nil
+	}
+	defer conn.Close()
+	_, err = fmt.Fprintf(conn, "%s\n", request)
+	if err != nil {
+		return "", true, err
+	}
+	bs, err := ioutil.ReadAll(conn)
+	if err != nil {
+		return "", true, err
+	}
+	parts := strings.SplitN(string(bs), "\n", 2)
+	if len(parts) != 2 {
+		return "", true, fmt.Errorf("Invalid reply from the server: %q", string(bs))
+	}
+	if parts[0] == replyError {
+		return "", true, fmt.Errorf("%s", parts[1])
+	}
+	return parts[1], true, nil
+}
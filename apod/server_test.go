@@ -0,0 +1,144 @@
+package apod
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serverForTest(t *testing.T) (*Server, string, string) {
+	f, testHome := frontendForTestConfigured(t)
+	makeTestWallpapers(t, f.Config, "140120", "140121", "140122")
+	makeStateFile(t, "140121", fit)
+	s := NewServer(f)
+	path := filepath.Join(testHome, socketBasename)
+	assert.NoError(t, s.Listen(path))
+	return s, path, testHome
+}
+
+func TestServer(t *testing.T) {
+	s, path, testHome := serverForTest(t)
+	defer cleanUp(t, testHome)
+	served := make(chan error)
+	go func() { served <- s.Serve() }()
+
+	for _, c := range [][2]string{
+		{"status", "apod:2014-01-21 fit"},
+		{"next", "apod:2014-01-22 fit"},
+		{"mode", "zoom"},
+		{"jump -2", "apod:2014-01-20 zoom"},
+		{"prev", ""},
+	} {
+		reply, forwarded, err := forward(path, c[0])
+		assert.True(t, forwarded)
+		if c[1] == "" {
+			assert.Equal(t, "Begin reached", err.Error())
+			continue
+		}
+		assert.NoError(t, err, c[0])
+		assert.Equal(t, c[1], reply, c[0])
+	}
+	_, _, err := forward(path, "jump far")
+	assert.Equal(t, "Invalid jump: far", err.Error())
+	_, _, err = forward(path, "shuffle")
+	assert.Equal(t, "Unknown request: shuffle", err.Error())
+
+	assert.Error(t, NewServer(s.Frontend).Listen(path), "A second server should not start")
+	assert.NoError(t, s.Close())
+	assert.NoError(t, <-served)
+	_, forwarded, err := forward(path, "status")
+	assert.False(t, forwarded)
+	assert.NoError(t, err)
+}
+
+func TestServerSeesChanges(t *testing.T) {
+	s, _, testHome := serverForTest(t)
+	defer cleanUp(t, testHome)
+	defer s.Close()
+	_, err := s.Do("next")
+	assert.NoError(t, err)
+	_, err = s.Do("next")
+	assert.Equal(t, "End reached", err.Error())
+	makeTestWallpapers(t, s.Config, "140123")
+	reply, err := s.Do("next")
+	assert.NoError(t, err)
+	assert.Equal(t, "apod:2014-01-23 fit", reply)
+	makeStateFile(t, "140120", zoom)
+	reply, err = s.Do("status")
+	assert.NoError(t, err)
+	assert.Equal(t, "apod:2014-01-20 zoom", reply, "the state set by another invocation is seen")
+}
+
+func TestServerFetchBeside(t *testing.T) {
+	s, _, testHome := serverForTest(t)
+	defer cleanUp(t, testHome)
+	defer s.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.Do("fetch many")
+	assert.Equal(t, "Invalid number of days: many", err.Error(), "a fetch does not wait for the other requests")
+	_, err = s.Do("fetch -parallel=two 1")
+	assert.Equal(t, `Invalid fetch request: invalid value "two" for flag -parallel: parse error`, err.Error())
+}
+
+func TestServerSeesConfigChanges(t *testing.T) {
+	s, _, testHome := serverForTest(t)
+	defer cleanUp(t, testHome)
+	defer s.Close()
+	reply, err := s.Do("next")
+	assert.NoError(t, err)
+	assert.Equal(t, "apod:2014-01-22 fit", reply)
+	cfg := *s.Config
+	cfg.Rules = []rule{{Exclude: true, From: adate("140122"), To: adate("140122")}}
+	assert.NoError(t, cfg.writeOut())
+	reply, err = s.Do("prev")
+	assert.NoError(t, err)
+	assert.Equal(t, "apod:2014-01-21 fit", reply)
+	_, err = s.Do("next")
+	assert.Equal(t, "End reached", err.Error(), "the rules written by another invocation are seen")
+
+	cfg.Sources = []string{"flickr"}
+	assert.NoError(t, cfg.writeOut())
+	_, err = s.Do("status")
+	assert.Equal(t, "Could not reload the configuration, because: Unknown source in configuration: flickr", err.Error())
+	_, err = s.Do("fetch 1")
+	assert.Error(t, err)
+	assert.Equal(t, []Source{s.APOD}, s.loader.Sources, "a configuration that fails to load is not taken")
+}
+
+// hangingServer answers no request, and reports each request the client gave up.
+type hangingServer chan struct{}
+
+func (s hangingServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	<-r.Context().Done()
+	s <- struct{}{}
+}
+
+func TestServerEndsFetchOfClientGone(t *testing.T) {
+	s, path, testHome := serverForTest(t)
+	defer cleanUp(t, testHome)
+	gone := make(hangingServer, 10)
+	server := httptest.NewServer(gone)
+	defer server.Close()
+	s.APOD.Site = server.URL + "/"
+	go s.Serve()
+	defer s.Close()
+
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	_, err = fmt.Fprintf(conn, "fetch 1\n")
+	assert.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+	select {
+	case <-gone:
+	case <-time.After(5 * time.Second):
+		t.Error("the fetch should end when the client hangs up")
+	}
+}
@@ -0,0 +1,261 @@
+package apod
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+const scriptSetterName = "script"
+
+// setScriptTemplate is written for the script setter, as a starting point
+// for exotic setups.
+const setScriptTemplate = `#!/bin/bash
+if test $WALLPAPER_OPTIONS = zoom; then
+	feh --bg-fill "$WALLPAPER"
+else
+	feh --bg-max "$WALLPAPER"
+fi
+`
+
+// Setter sets the wallpaper of the desktop.
+type Setter interface {
+	// Set displays the image in file, zoomed or fitted according to options.
+	Set(file, options string) error
+}
+
+// runner runs an external command and returns its combined output.
+type runner func(name string, args ...string) ([]byte, error)
+
+func execRunner(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// run runs the command with r and adds the output of a failing command to the error.
+func (r runner) run(name string, args ...string) error {
+	output, err := r(name, args...)
+	if err != nil {
+		return fmt.Errorf("Error running %s: %v. Output: %s", name, err, string(output))
+	}
+	return nil
+}
+
+// setters constructs the setters by the name used in the configuration.
+var setters = map[string]func(runner) Setter{
+	"feh":            func(r runner) Setter { return fehSetter{r} },
+	"pcmanfm":        func(r runner) Setter { return pcmanfmSetter{r} },
+	"gsettings":      func(r runner) Setter { return gsettingsSetter{r} },
+	"xfce":           func(r runner) Setter { return xfceSetter{r} },
+	"kde":            func(r runner) Setter { return kdeSetter{r} },
+	"sway":           func(r runner) Setter { return swaySetter{r} },
+	"hyprpaper":      func(r runner) Setter { return hyprpaperSetter{r} },
+	"xwallpaper":     func(r runner) Setter { return xwallpaperSetter{r} },
+	"nitrogen":       func(r runner) Setter { return nitrogenSetter{r} },
+	scriptSetterName: func(r runner) Setter { return scriptSetter{r} },
+}
+
+// setterAliases map the configuration types of earlier versions to setters.
+var setterAliases = map[string]string{
+	"barewm": "feh",
+	"lxde":   "pcmanfm",
+	"gnome":  "gsettings",
+}
+
+// setterNames returns the names of all setters, sorted.
+func setterNames() []string {
+	var names []string
+	for name := range setters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newSetter constructs the named setter, the script setter if name is empty.
+func newSetter(name string, r runner) (Setter, error) {
+	if name == "" {
+		name = scriptSetterName
+	}
+	factory, ok := setters[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown wallpaper setter: %s", name)
+	}
+	return factory(r), nil
+}
+
+type fehSetter struct{ run runner }
+
+func (s fehSetter) Set(file, options string) error {
+	if options == zoom {
+		return s.run.run("feh", "--bg-fill", file)
+	}
+	return s.run.run("feh", "--bg-max", file)
+}
+
+type pcmanfmSetter struct{ run runner }
+
+func (s pcmanfmSetter) Set(file, options string) error {
+	mode := "fit"
+	if options == zoom {
+		mode = "crop"
+	}
+	return s.run.run("pcmanfm", "--set-wallpaper="+file, "--wallpaper-mode="+mode)
+}
+
+type gsettingsSetter struct{ run runner }
+
+func (s gsettingsSetter) Set(file, options string) error {
+	const schema = "org.gnome.desktop.background"
+	mode := "scaled"
+	if options == zoom {
+		mode = "zoom"
+	}
+	for _, kv := range [][2]string{
+		{"picture-uri", "file://" + file},
+		{"picture-options", mode},
+		{"primary-color", "000000"},
+		{"secondary-color", "000000"},
+	} {
+		if err := s.run.run("gsettings", "set", schema, kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+	// picture-uri-dark is only known to GNOME 42 and later
+	s.run("gsettings", "set", schema, "picture-uri-dark", "file://"+file)
+	return nil
+}
+
+// xfceSetter sets the image on every monitor and workspace of xfdesktop.
+type xfceSetter struct{ run runner }
+
+func (s xfceSetter) Set(file, options string) error {
+	// xfdesktop image styles: 4 is scaled, 5 is zoomed
+	style := "4"
+	if options == zoom {
+		style = "5"
+	}
+	output, err := s.run("xfconf-query", "-c", "xfce4-desktop", "-l")
+	if err != nil {
+		return fmt.Errorf("Error running xfconf-query: %v. Output: %s", err, string(output))
+	}
+	found := false
+	for _, prop := range strings.Fields(string(output)) {
+		if !strings.HasSuffix(prop, "/last-image") {
+			continue
+		}
+		found = true
+		if err := s.run.run("xfconf-query", "-c", "xfce4-desktop", "-p", prop, "-s", file); err != nil {
+			return err
+		}
+		styleProp := strings.TrimSuffix(prop, "last-image") + "image-style"
+		if err := s.run.run("xfconf-query", "-c", "xfce4-desktop", "-p", styleProp, "-s", style); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return fmt.Errorf("No xfdesktop backdrops found")
+	}
+	return nil
+}
+
+// kdeSetter sets the image on all Plasma desktops through the plasmashell scripting API.
+type kdeSetter struct{ run runner }
+
+const kdeScript = `var all = desktops();
+for (var i = 0; i < all.length; i++) {
+	var d = all[i];
+	d.wallpaperPlugin = "org.kde.image";
+	d.currentConfigGroup = Array("Wallpaper", "org.kde.image", "General");
+	d.writeConfig("Image", %s);
+	d.writeConfig("FillMode", %d);
+}`
+
+func (s kdeSetter) Set(file, options string) error {
+	// Plasma fill modes: 1 is preserve aspect fit, 2 is preserve aspect crop
+	mode := 1
+	if options == zoom {
+		mode = 2
+	}
+	// The path is quoted as a JSON string, which is a valid JavaScript string.
+	image, err := json.Marshal("file://" + file)
+	if err != nil {
+		return err
+	}
+	script := fmt.Sprintf(kdeScript, image, mode)
+	return s.run.run("qdbus", "org.kde.plasmashell", "/PlasmaShell", "org.kde.PlasmaShell.evaluateScript", script)
+}
+
+// swaySetter has sway run swaybg on all outputs.
+type swaySetter struct{ run runner }
+
+func (s swaySetter) Set(file, options string) error {
+	mode := "fit"
+	if options == zoom {
+		mode = "fill"
+	}
+	return s.run.run("swaymsg", "output", "*", "bg", file, mode)
+}
+
+type hyprpaperSetter struct{ run runner }
+
+func (s hyprpaperSetter) Set(file, options string) error {
+	if err := s.run.run("hyprctl", "hyprpaper", "preload", file); err != nil {
+		return err
+	}
+	target := file
+	if options != zoom {
+		target = "contain:" + file
+	}
+	if err := s.run.run("hyprctl", "hyprpaper", "wallpaper", ","+target); err != nil {
+		return err
+	}
+	return s.run.run("hyprctl", "hyprpaper", "unload", "unused")
+}
+
+type xwallpaperSetter struct{ run runner }
+
+func (s xwallpaperSetter) Set(file, options string) error {
+	if options == zoom {
+		return s.run.run("xwallpaper", "--zoom", file)
+	}
+	return s.run.run("xwallpaper", "--maximize", file)
+}
+
+type nitrogenSetter struct{ run runner }
+
+func (s nitrogenSetter) Set(file, options string) error {
+	if options == zoom {
+		return s.run.run("nitrogen", "--set-zoom-fill", "--save", file)
+	}
+	return s.run.run("nitrogen", "--set-scaled", "--save", file)
+}
+
+// scriptSetter runs the user's set-wallpaper.sh with the image and options
+// in the WALLPAPER and WALLPAPER_OPTIONS environment variables. For a
+// different image on each output it runs once per output, with its name in
+// WALLPAPER_OUTPUT.
+type scriptSetter struct{ run runner }
+
+func (s scriptSetter) Set(file, options string) error {
+	return s.runScript(file, options, "")
+}
+
+func (s scriptSetter) SetOutputs(outputs []Output) error {
+	for _, o := range outputs {
+		if err := s.runScript(o.File, o.Options, o.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s scriptSetter) runScript(file, options, name string) error {
+	// env runs the script with the variables added to the environment.
+	output, err := s.run("env", "WALLPAPER="+file, "WALLPAPER_OPTIONS="+options, "WALLPAPER_OUTPUT="+name, wallpaperSetScript())
+	if err != nil {
+		return fmt.Errorf("Error running Wallpaper-Set-Script: %v. Output: %s", err, string(output))
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package apod
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRunner records the commands it is asked to run and answers them
+// with output, failing for commands starting with fail.
+type fakeRunner struct {
+	calls  []string
+	output string
+	fail   string
+}
+
+func (f *fakeRunner) run(name string, args ...string) ([]byte, error) {
+	call := strings.Join(append([]string{name}, args...), " ")
+	f.calls = append(f.calls, call)
+	if f.fail != "" && strings.HasPrefix(call, f.fail) {
+		return []byte("oops"), fmt.Errorf("exit status 1")
+	}
+	return []byte(f.output), nil
+}
+
+func setForTest(t *testing.T, name, options string) *fakeRunner {
+	r := &fakeRunner{}
+	s, err := newSetter(name, r.run)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Set("/w/apod-img-2014-09-21", options))
+	return r
+}
+
+func TestSetters(t *testing.T) {
+	for _, c := range []struct {
+		name, options string
+		calls         []string
+	}{
+		{"feh", fit, []string{"feh --bg-max /w/apod-img-2014-09-21"}},
+		{"feh", zoom, []string{"feh --bg-fill /w/apod-img-2014-09-21"}},
+		{"pcmanfm", zoom, []string{"pcmanfm --set-wallpaper=/w/apod-img-2014-09-21 --wallpaper-mode=crop"}},
+		{"sway", fit, []string{"swaymsg output * bg /w/apod-img-2014-09-21 fit"}},
+		{"xwallpaper", zoom, []string{"xwallpaper --zoom /w/apod-img-2014-09-21"}},
+		{"nitrogen", fit, []string{"nitrogen --set-scaled --save /w/apod-img-2014-09-21"}},
+		{"hyprpaper", fit, []string{
+			"hyprctl hyprpaper preload /w/apod-img-2014-09-21",
+			"hyprctl hyprpaper wallpaper ,contain:/w/apod-img-2014-09-21",
+			"hyprctl hyprpaper unload unused"}},
+		{"gsettings", zoom, []string{
+			"gsettings set org.gnome.desktop.background picture-uri file:///w/apod-img-2014-09-21",
+			"gsettings set org.gnome.desktop.background picture-options zoom",
+			"gsettings set org.gnome.desktop.background primary-color 000000",
+			"gsettings set org.gnome.desktop.background secondary-color 000000",
+			"gsettings set org.gnome.desktop.background picture-uri-dark file:///w/apod-img-2014-09-21"}},
+	} {
+		r := setForTest(t, c.name, c.options)
+		assert.Equal(t, c.calls, r.calls, c.name)
+	}
+}
+
+func TestKDESetter(t *testing.T) {
+	r := setForTest(t, "kde", zoom)
+	assert.Equal(t, 1, len(r.calls))
+	assert.True(t, strings.HasPrefix(r.calls[0], "qdbus org.kde.plasmashell /PlasmaShell org.kde.PlasmaShell.evaluateScript"))
+	assert.Contains(t, r.calls[0], `d.writeConfig("Image", "file:///w/apod-img-2014-09-21");`)
+	assert.Contains(t, r.calls[0], `d.writeConfig("FillMode", 2);`)
+}
+
+func TestKDESetterQuotes(t *testing.T) {
+	r := &fakeRunner{}
+	s, err := newSetter("kde", r.run)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Set(`/w/it's "here"\`, fit))
+	assert.Contains(t, r.calls[0], `d.writeConfig("Image", "file:///w/it's \"here\"\\");`)
+}
+
+func TestScriptSetter(t *testing.T) {
+	r := &fakeRunner{}
+	s, err := newSetter(scriptSetterName, r.run)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Set("/w/apod-img-2014-09-21", zoom))
+	assert.NoError(t, s.(OutputSetter).SetOutputs([]Output{{Monitor: Monitor{Name: "HDMI-1"}, File: "/w/a", Options: fit}}))
+	assert.Equal(t, []string{
+		"env WALLPAPER=/w/apod-img-2014-09-21 WALLPAPER_OPTIONS=zoom WALLPAPER_OUTPUT= " + wallpaperSetScript(),
+		"env WALLPAPER=/w/a WALLPAPER_OPTIONS=fit WALLPAPER_OUTPUT=HDMI-1 " + wallpaperSetScript()}, r.calls)
+	r.fail = "env"
+	assert.Equal(t, "Error running Wallpaper-Set-Script: exit status 1. Output: oops", s.Set("/w/a", fit).Error())
+}
+
+func TestXfceSetter(t *testing.T) {
+	r := &fakeRunner{output: "/backdrop/screen0/monitorHDMI-1/workspace0/last-image\n/backdrop/screen0/monitorHDMI-1/workspace0/image-style\n"}
+	s, err := newSetter("xfce", r.run)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Set("/w/img", fit))
+	assert.Equal(t, []string{
+		"xfconf-query -c xfce4-desktop -l",
+		"xfconf-query -c xfce4-desktop -p /backdrop/screen0/monitorHDMI-1/workspace0/last-image -s /w/img",
+		"xfconf-query -c xfce4-desktop -p /backdrop/screen0/monitorHDMI-1/workspace0/image-style -s 4"}, r.calls)
+}
+
+func TestXfceSetterWithoutBackdrops(t *testing.T) {
+	r := &fakeRunner{}
+	s, err := newSetter("xfce", r.run)
+	assert.NoError(t, err)
+	assert.Equal(t, "No xfdesktop backdrops found", s.Set("/w/img", fit).Error())
+}
+
+func TestSetterFailure(t *testing.T) {
+	r := &fakeRunner{fail: "feh"}
+	s, err := newSetter("feh", r.run)
+	assert.NoError(t, err)
+	assert.Equal(t, "Error running feh: exit status 1. Output: oops", s.Set("/w/img", fit).Error())
+}
+
+func TestUnknownSetter(t *testing.T) {
+	_, err := newSetter("windows", execRunner)
+	assert.Equal(t, "Unknown wallpaper setter: windows", err.Error())
+}
@@ -0,0 +1,99 @@
+package apod
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const shuffleBasename = "shuffle.json"
+
+func shuffleFile() string {
+	return filepath.Join(configDir(), shuffleBasename)
+}
+
+// readDrawn returns how often the wallpapers were drawn from the shuffle bag
+// in this round.
+func readDrawn() (map[string]int, error) {
+	drawn := make(map[string]int)
+	bs, err := ioutil.ReadFile(shuffleFile())
+	if os.IsNotExist(err) {
+		return drawn, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []string
+	if err := json.Unmarshal(bs, &list); err != nil {
+		return nil, err
+	}
+	for _, w := range list {
+		drawn[w]++
+	}
+	return drawn, nil
+}
+
+// storeDrawn remembers the wallpapers drawn in this round, a wallpaper drawn
+// more than once is listed as often.
+func storeDrawn(drawn map[string]int) error {
+	list := []string{}
+	for w, n := range drawn {
+		for i := 0; i < n; i++ {
+			list = append(list, w)
+		}
+	}
+	sort.Strings(list)
+	bs, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(shuffleFile(), bs, 0644)
+}
+
+// drawFromBag draws one of ws from the shuffle bag, which holds every
+// wallpaper once, and every favorite favoriteWeight times, per round. So the
+// others are shown once before any is shown again, and favorites are shown
+// favoriteWeight times as often. Wallpapers downloaded later join the round.
+// When the bag is empty a new round starts. last, the wallpaper shown
+// before, is never drawn.
+func drawFromBag(ws []Wallpaper, last Wallpaper) (Wallpaper, error) {
+	drawn, err := readDrawn()
+	if err != nil {
+		return Wallpaper{}, err
+	}
+	marks, err := readMarks()
+	if err != nil {
+		return Wallpaper{}, err
+	}
+	bag, left := inBag(ws, drawn, marks, last)
+	if len(bag) == 0 {
+		drawn = make(map[string]int)
+		bag, left = inBag(ws, drawn, marks, last)
+	}
+	if len(bag) == 0 {
+		// last is the only wallpaper there is
+		return last, nil
+	}
+	w := pickRandom(bag, left)
+	drawn[w.String()]++
+	return w, storeDrawn(drawn)
+}
+
+// inBag returns the wallpapers of ws left in the bag, leaving out last, and
+// how often each is left.
+func inBag(ws []Wallpaper, drawn map[string]int, marks map[string]string, last Wallpaper) ([]Wallpaper, []int) {
+	var (
+		bag  []Wallpaper
+		left []int
+	)
+	for _, w := range ws {
+		n := weight(w, marks) - drawn[w.String()]
+		if n > 0 && w.String() != last.String() {
+			bag = append(bag, w)
+			left = append(left, n)
+		}
+	}
+	return bag, left
+}
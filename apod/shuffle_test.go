@@ -0,0 +1,93 @@
+package apod
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrawFromBag(t *testing.T) {
+	_, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	ws := []Wallpaper{apodOn("140119"), apodOn("140120"), apodOn("140121")}
+	seen := make(map[string]bool)
+	var last Wallpaper
+	for i := 0; i < len(ws); i++ {
+		w, err := drawFromBag(ws, last)
+		assert.NoError(t, err)
+		assert.False(t, seen[w.String()], "%s drawn twice in a round", w)
+		seen[w.String()] = true
+		last = w
+	}
+	drawn, err := readDrawn()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(drawn))
+
+	w, err := drawFromBag(ws, last)
+	assert.NoError(t, err)
+	assert.NotEqual(t, last.String(), w.String(), "a new round does not start with the last one")
+	drawn, err = readDrawn()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{w.String(): 1}, drawn)
+
+	w, err = drawFromBag(ws[:1], ws[0])
+	assert.NoError(t, err)
+	assert.Equal(t, ws[0], w, "the only wallpaper is shown again")
+}
+
+func TestDrawFromBagFavorites(t *testing.T) {
+	_, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	var ws []Wallpaper
+	for day := 10; day < 20; day++ {
+		ws = append(ws, apodOn(fmt.Sprintf("1401%d", day)))
+	}
+	favorite := ws[3]
+	assert.NoError(t, storeMark(favorite, markFavorite))
+	count := make(map[string]int)
+	last := Wallpaper{}
+	for i := 0; i < 13*50; i++ {
+		w, err := drawFromBag(ws, last)
+		assert.NoError(t, err)
+		assert.NotEqual(t, last.String(), w.String())
+		count[w.String()]++
+		last = w
+	}
+	assert.InDelta(t, 200, count[favorite.String()], 20, "a favorite is shown four times as often")
+	assert.InDelta(t, 50, count[ws[0].String()], 5)
+}
+
+func TestDrawFromBagNewDownloads(t *testing.T) {
+	_, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	ws := []Wallpaper{apodOn("140119"), apodOn("140120")}
+	first, err := drawFromBag(ws, Wallpaper{})
+	assert.NoError(t, err)
+	ws = append(ws, apodOn("140121"))
+	for i := 0; i < 2; i++ {
+		w, err := drawFromBag(ws, first)
+		assert.NoError(t, err)
+		assert.NotEqual(t, first.String(), w.String())
+	}
+	drawn, err := readDrawn()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(drawn))
+}
+
+func TestRandomArchiveShowsAllOnce(t *testing.T) {
+	f, testHome := commandForTest(t)
+	defer cleanUp(t, testHome)
+	last := "apod:2014-01-20"
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, f.RandomArchive())
+		s, err := f.State()
+		assert.NoError(t, err)
+		w := s.Wallpaper().String()
+		assert.NotEqual(t, last, w, "shown twice in a row")
+		assert.False(t, seen[w], "%s shown twice", w)
+		seen[w] = true
+		last = w
+	}
+}
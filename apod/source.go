@@ -0,0 +1,116 @@
+package apod
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const apodName = "apod"
+
+// Source is a provider of a daily image, like apod.nasa.gov.
+type Source interface {
+	// Name identifies the source in file names and state, it may not contain "-img-".
+	Name() string
+	// Dates lists the dates from to to, both inclusive, on which the source
+	// may have an entry, newest first.
+	Dates(from, to ADate) []ADate
+	// Entry resolves the entry of a date to its image URL and metadata.
+	Entry(ctx context.Context, date ADate) (*Entry, error)
+	// PageURL returns the web page on the entry of a date.
+	PageURL(date ADate) string
+	// Download stores the image at url in file.
+	Download(ctx context.Context, file, url string) error
+}
+
+// sourceFactories construct the sources that can be named in the configuration,
+// apod is not listed as the Frontend brings its own.
+var sourceFactories = map[string]func(*Fetcher, Clock) Source{
+	bingName: func(f *Fetcher, c Clock) Source {
+		b := NewBing(f)
+		b.Clock = c
+		return b
+	},
+}
+
+// Wallpaper identifies a downloaded image by its source and date.
+type Wallpaper struct {
+	Source string
+	Date   ADate
+}
+
+// String returns the wallpaper as source:date, the date in ISO form.
+func (w Wallpaper) String() string {
+	return w.Source + ":" + w.Date.String()
+}
+
+// MarshalText implements encoding.TextMarshaler in the form of String.
+func (w Wallpaper) MarshalText() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, a date without a
+// source denotes an APOD.
+func (w *Wallpaper) UnmarshalText(b []byte) error {
+	p, err := ParseWallpaper(string(b))
+	if err != nil {
+		return err
+	}
+	*w = p
+	return nil
+}
+
+// ParseWallpaper parses source:date, or a plain date for an APOD.
+func ParseWallpaper(s string) (Wallpaper, error) {
+	source := apodName
+	if i := strings.Index(s, ":"); i >= 0 {
+		source, s = s[:i], s[i+1:]
+	}
+	d, err := ParseADate(s)
+	if err != nil {
+		return Wallpaper{}, err
+	}
+	return Wallpaper{Source: source, Date: d}, nil
+}
+
+// before orders wallpapers by date, then by source.
+func (w Wallpaper) before(o Wallpaper) bool {
+	if w.Date == o.Date {
+		return w.Source < o.Source
+	}
+	return w.Date.Before(o.Date)
+}
+
+func sortWallpapers(ws []Wallpaper) {
+	sort.Slice(ws, func(i, j int) bool { return ws[i].before(ws[j]) })
+}
+
+// Name returns apod.
+func (a *APOD) Name() string {
+	return apodName
+}
+
+// Dates lists every day from to to, but not before the first APOD.
+func (a *APOD) Dates(from, to ADate) []ADate {
+	var dates []ADate
+	for d := to; !d.Before(from) && !d.Before(FirstDate); d = d.Back() {
+		dates = append(dates, d)
+	}
+	return dates
+}
+
+// PageURL returns the APOD page of the date.
+func (a *APOD) PageURL(date ADate) string {
+	return a.UrlForDate(date)
+}
+
+// source looks up a configured source by name.
+func source(sources []Source, name string) (Source, error) {
+	for _, s := range sources {
+		if s.Name() == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown source: %s", name)
+}
@@ -0,0 +1,100 @@
+package apod
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWallpaper(t *testing.T) {
+	for in, expected := range map[string]Wallpaper{
+		"2014-09-21":      apodOn("140921"),
+		"140921":          apodOn("140921"),
+		"apod:2014-09-21": apodOn("140921"),
+		"bing:2014-09-21": {Source: bingName, Date: adate("140921")},
+	} {
+		w, err := ParseWallpaper(in)
+		assert.NoError(t, err, in)
+		assert.Equal(t, expected, w, in)
+	}
+	_, err := ParseWallpaper("bing:yesterday")
+	assert.Error(t, err)
+}
+
+func TestWallpaperString(t *testing.T) {
+	assert.Equal(t, "bing:2014-09-21", Wallpaper{Source: bingName, Date: adate("140921")}.String())
+}
+
+func TestParseFileName(t *testing.T) {
+	w, ok := parseFileName("bing-img-2014-09-21")
+	assert.True(t, ok)
+	assert.Equal(t, Wallpaper{Source: bingName, Date: adate("140921")}, w)
+	for _, name := range []string{"-img-2014-09-21", "apod-meta-2014-09-21.json", "apod-img-2014-09-21.part", "config.json"} {
+		_, ok := parseFileName(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestAPODDates(t *testing.T) {
+	a := NewAPOD()
+	assert.Equal(t, []ADate{adate("140922"), adate("140921"), adate("140920")}, a.Dates(adate("140920"), adate("140922")))
+	assert.Equal(t, []ADate{adate("950617"), adate("950616")}, a.Dates(adate("950601"), adate("950617")))
+}
+
+func TestDownloadedWallpapersMixedSources(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, a.Config, "140120", "140121")
+	bing := Wallpaper{Source: bingName, Date: adate("140120")}
+	assert.NoError(t, ioutil.WriteFile(a.Config.fileName(bing), []byte{}, 0644))
+
+	files, err := a.storage.DownloadedWallpapers()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140120"), bing, apodOn("140121")}, files)
+}
+
+func TestJumpAcrossSources(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeStateFile(t, "140120", "fit")
+	makeTestWallpapers(t, f.Config, "140120", "140121")
+	bing := Wallpaper{Source: bingName, Date: adate("140120")}
+	assert.NoError(t, ioutil.WriteFile(f.Config.fileName(bing), []byte{}, 0644))
+
+	assert.NoError(t, f.Jump(1))
+	s, err := f.State()
+	assert.NoError(t, err)
+	assert.Equal(t, bing, s.Wallpaper())
+}
+
+func TestSourcesFromConfig(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	f.Config.Sources = []string{bingName, apodName}
+	assert.NoError(t, f.Config.writeOut())
+	assert.NoError(t, f.Loadconfig())
+	assert.Equal(t, 2, len(f.loader.Sources))
+	assert.Equal(t, bingName, f.loader.Sources[0].Name())
+	assert.Equal(t, f.APOD, f.loader.Sources[1])
+}
+
+func TestSourcesFromConfigUnknown(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	f.Config.Sources = []string{"flickr"}
+	assert.NoError(t, f.Config.writeOut())
+	assert.Equal(t, "Unknown source in configuration: flickr", f.Loadconfig().Error())
+}
+
+func TestPageURL(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	assert.Equal(t, testAPODSite+"apod/ap140121.html", f.pageURL(apodOn("140121")))
+	bing := Wallpaper{Source: bingName, Date: adate("140121")}
+	assert.Equal(t, bingSite, f.pageURL(bing))
+	assert.NoError(t, f.Config.writeEntry(&Entry{Source: bingName, Date: bing.Date, URL: "http://example.com/"}))
+	assert.Equal(t, "http://example.com/", f.pageURL(bing))
+	assert.Equal(t, filepath.Join(f.Config.WallpaperDir, "bing-meta-2014-01-21.json"), f.Config.metaFileName(bing))
+}
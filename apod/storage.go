@@ -1,24 +1,114 @@
 package apod
 
 import (
+	"encoding/json"
 	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"net/http"
 	"os"
-	"sort"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
-const imgPrefix = "apod-img-"
+const (
+	imgInfix  = "-img-"
+	metaInfix = "-meta-"
+	// imgPrefix and metaPrefix name the files of APOD images
+	imgPrefix  = apodName + imgInfix
+	metaPrefix = apodName + metaInfix
+)
 
-func stripPrefix(s string) string {
-	return s[len(imgPrefix):]
+// imageTypes maps the content types of the images accepted to their extension.
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
 }
 
+// imageExtensions are the extensions of imageTypes.
+var imageExtensions = []string{".jpg", ".png", ".gif"}
+
 type Storage struct {
 	Config *config
+	// cache keeps the listing of DownloadedWallpapers until the wallpaper
+	// directory changes
+	cache      bool
+	wallpapers []Wallpaper
+	// listed is the modification time of the directory when it was listed
+	listed time.Time
+}
+
+// parseFileName returns the wallpaper an image file name of the form
+// source-img-date, followed by the extension of its type or not, stands for.
+func parseFileName(name string) (Wallpaper, bool) {
+	i := strings.Index(name, imgInfix)
+	if i <= 0 {
+		return Wallpaper{}, false
+	}
+	for _, ext := range imageExtensions {
+		name = strings.TrimSuffix(name, ext)
+	}
+	d, err := ParseADate(name[i+len(imgInfix):])
+	if err != nil {
+		return Wallpaper{}, false
+	}
+	return Wallpaper{Source: name[:i], Date: d}, true
 }
 
-// IsDownloaded checks whether an image file is downloaded for a given date.
-func (c *config) IsDownloaded(isodate ADate) (bool, error) {
-	file := c.fileName(isodate)
+// validateImage checks by its content that a downloaded file holds an image,
+// and renames it to the extension of its type. A file that is no image, or
+// not all of one, is removed. It returns the new name.
+func validateImage(file string) (string, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		r.Close()
+		return "", err
+	}
+	contentType := http.DetectContentType(head[:n])
+	ext, ok := imageTypes[contentType]
+	if ok {
+		_, err = r.Seek(0, io.SeekStart)
+		if err == nil {
+			_, _, err = image.Decode(r)
+		}
+	}
+	r.Close()
+	if !ok {
+		os.Remove(file)
+		return "", fmt.Errorf("Not an image, but %s", contentType)
+	}
+	if err != nil {
+		os.Remove(file)
+		return "", fmt.Errorf("Not a valid image, because: %v", err)
+	}
+	return file + ext, os.Rename(file, file+ext)
+}
+
+// imageSize reads the size of the image in file.
+func imageSize(file string) (image.Point, error) {
+	r, err := os.Open(file)
+	if err != nil {
+		return image.Point{}, err
+	}
+	defer r.Close()
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return image.Point{}, err
+	}
+	return image.Pt(cfg.Width, cfg.Height), nil
+}
+
+// IsDownloaded checks whether an image file is downloaded for a given wallpaper.
+func (c *config) IsDownloaded(w Wallpaper) (bool, error) {
+	file := c.fileName(w)
 	fileExists, err := exists(file)
 	if err != nil {
 		return false, err
@@ -26,33 +116,139 @@ func (c *config) IsDownloaded(isodate ADate) (bool, error) {
 	return fileExists, nil
 }
 
-func (s *Storage) DownloadedWallpapers() ([]ADate, error) {
-	dir, err := os.Open(s.Config.WallpaperDir)
+// DownloadedWallpapers returns all downloaded images in chronological order,
+// images of the same day are ordered by source.
+func (s *Storage) DownloadedWallpapers() ([]Wallpaper, error) {
+	var listed time.Time
+	if s.cache {
+		listed = modTime(s.Config.WallpaperDir)
+		if s.wallpapers != nil && listed.Equal(s.listed) {
+			return append([]Wallpaper{}, s.wallpapers...), nil
+		}
+	}
+	files, err := s.files()
 	if err != nil {
 		return nil, err
 	}
-	files, err := dir.Readdirnames(0)
+	wallpapers := []Wallpaper{}
+	seen := make(map[Wallpaper]bool)
+	for _, f := range files {
+		if w, ok := parseFileName(f); ok && !seen[w] {
+			seen[w] = true
+			wallpapers = append(wallpapers, w)
+		}
+	}
+	sortWallpapers(wallpapers)
+	// a change within the resolution of the modification time could go
+	// unnoticed, so a listing is only kept of a directory that is settled
+	if s.cache && time.Since(listed) > time.Second {
+		s.wallpapers = append([]Wallpaper{}, wallpapers...)
+		s.listed = listed
+	}
+	return wallpapers, nil
+}
+
+func (s *Storage) files() ([]string, error) {
+	dir, err := os.Open(s.Config.WallpaperDir)
 	if err != nil {
 		return nil, err
 	}
-	sort.Strings(files)
-	dates := []ADate{}
+	defer dir.Close()
+	return dir.Readdirnames(0)
+}
+
+// migrate renames images and metadata stored under the six digit date code
+// of earlier versions to their ISO date names.
+func (s *Storage) migrate() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
 	for _, f := range files {
-		dates = append(dates, ADate(stripPrefix(f)))
+		for _, prefix := range []string{imgPrefix, metaPrefix} {
+			if !strings.HasPrefix(f, prefix) {
+				continue
+			}
+			code := strings.TrimSuffix(f[len(prefix):], ".json")
+			if len(code) != len(format) {
+				continue
+			}
+			d, err := ParseADate(code)
+			if err != nil {
+				continue
+			}
+			name := prefix + d.String() + f[len(prefix)+len(code):]
+			err = os.Rename(filepath.Join(s.Config.WallpaperDir, f), filepath.Join(s.Config.WallpaperDir, name))
+			if err != nil {
+				return err
+			}
+		}
 	}
-	return dates, nil
+	return nil
 }
 
 // IndexOf returns the index of an image in the wallpaper directory.
-func (s *Storage) IndexOf(isodate ADate) (int, error) {
+func (s *Storage) IndexOf(w Wallpaper) (int, error) {
 	all, err := s.DownloadedWallpapers()
 	if err != nil {
 		return 0, err
 	}
 	for i, elem := range all {
-		if elem == isodate {
+		if elem == w {
 			return i, nil
 		}
 	}
-	return 0, fmt.Errorf("%s was not found", isodate)
+	return 0, fmt.Errorf("%s was not found", w)
+}
+
+// Entry reads back the metadata stored next to the image of the given wallpaper.
+func (s *Storage) Entry(w Wallpaper) (*Entry, error) {
+	bs, err := ioutil.ReadFile(s.Config.metaFileName(w))
+	if err != nil {
+		return nil, err
+	}
+	e := new(Entry)
+	err = json.Unmarshal(bs, e)
+	if err != nil {
+		return nil, err
+	}
+	if e.Source == "" {
+		e.Source = w.Source
+	}
+	return e, nil
+}
+
+// writeEntry stores the metadata of an image next to it.
+func (c *config) writeEntry(e *Entry) error {
+	bs, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.metaFileName(e.Wallpaper()), bs, 0644)
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of file
+// and renames it into place, so readers never see a partially written file.
+func writeFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), "."+filepath.Base(file)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
 }
@@ -3,21 +3,70 @@ package apod
 import (
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 )
 
 func makeTestWallpapers(t testing.TB, c *config, files ...string) {
 	for _, file := range files {
-		err := ioutil.WriteFile(c.fileName(ADate(file)), []byte{}, 0644)
+		err := ioutil.WriteFile(c.fileName(apodOn(file)), []byte{}, 0644)
 		assert.NoError(t, err)
 	}
 }
 
 func TestFileName(t *testing.T) {
 	c := config{WallpaperDir: "foo"}
-	expected := filepath.Join("foo", "apod-img-140121")
-	assert.Equal(t, expected, c.fileName(testDateString))
+	expected := filepath.Join("foo", "apod-img-2014-01-21")
+	assert.Equal(t, expected, c.fileName(apodOn(testDateString)))
+}
+
+func TestFileNameWithExtension(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	base := a.Config.fileName(apodOn("140121"))
+	assert.NoError(t, ioutil.WriteFile(base+".png", []byte{}, 0644))
+	assert.Equal(t, base+".png", a.Config.fileName(apodOn("140121")))
+}
+
+func TestDownloadedWallpapersMixedNaming(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, a.Config, "140120", "140121")
+	for _, name := range []string{"apod-img-2014-01-19.jpg", "apod-img-2014-01-21.gif", "apod-img-2014-01-22.part", "apod-img-2014-01-23.html"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(a.Config.WallpaperDir, name), []byte{}, 0644))
+	}
+	files, err := a.storage.DownloadedWallpapers()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140119"), apodOn("140120"), apodOn("140121")}, files)
+}
+
+func TestValidateImage(t *testing.T) {
+	testHome := setupTestHome(t)
+	defer cleanUp(t, testHome)
+	jpeg, err := ioutil.ReadFile("../testdata/apod.nasa.gov/apod/image/1409/m8_chua_2500.jpg")
+	assert.NoError(t, err)
+	file := filepath.Join(testHome, "apod-img-2014-09-21")
+	for content, expected := range map[string]string{
+		"<html><body>Service unavailable</body></html>": "Not an image, but text/html; charset=utf-8",
+		string(jpeg[:20]):          "Not a valid image, because: unexpected EOF",
+		string(jpeg[:len(jpeg)/2]): "Not a valid image, because: invalid JPEG format: short Huffman data",
+	} {
+		assert.NoError(t, ioutil.WriteFile(file, []byte(content), 0644))
+		_, err := validateImage(file)
+		assert.Equal(t, expected, err.Error())
+		present, err := exists(file)
+		assert.NoError(t, err)
+		assert.False(t, present, "a file that is no image is removed")
+	}
+	assert.NoError(t, ioutil.WriteFile(file, jpeg, 0644))
+	valid, err := validateImage(file)
+	assert.NoError(t, err)
+	assert.Equal(t, file+".jpg", valid)
+	present, err := exists(valid)
+	assert.NoError(t, err)
+	assert.True(t, present)
 }
 
 func TestDownloadedWallpapers(t *testing.T) {
@@ -34,7 +83,7 @@ func TestIndexOfPresent(t *testing.T) {
 	a, testHome := frontendForTestConfigured(t)
 	defer cleanUp(t, testHome)
 	makeTestWallpapers(t, a.Config, "140120", "140121")
-	i, err := a.storage.IndexOf("140121")
+	i, err := a.storage.IndexOf(apodOn("140121"))
 	assert.NoError(t, err)
 	assert.Equal(t, 1, i)
 }
@@ -43,9 +92,75 @@ func TestIndexOfAbsent(t *testing.T) {
 	a, testHome := frontendForTestConfigured(t)
 	defer cleanUp(t, testHome)
 	makeTestWallpapers(t, a.Config)
-	_, err := a.storage.IndexOf("130101")
+	_, err := a.storage.IndexOf(apodOn("130101"))
 	if err == nil {
 		t.Fatal("Expected an error, got nil")
 	}
-	assert.Equal(t, "130101 was not found", err.Error())
+	assert.Equal(t, "apod:2013-01-01 was not found", err.Error())
+}
+
+func TestDownloadedWallpapersSkipsOtherFiles(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, a.Config, "140120", "140121")
+	assert.NoError(t, a.Config.writeEntry(&Entry{Source: apodName, Date: adate("140121"), Title: "Foo"}))
+
+	files, err := a.storage.DownloadedWallpapers()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("140120"), apodOn("140121")}, files)
+}
+
+func TestStorageEntry(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	in := &Entry{Source: apodName, Date: adate("140121"), Title: "Foo", Keywords: []string{"bar", "baz"}}
+	assert.NoError(t, a.Config.writeEntry(in))
+	out, err := a.storage.Entry(apodOn("140121"))
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+}
+
+func TestStorageEntryAbsent(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	_, err := a.storage.Entry(apodOn("140121"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	testHome := setupTestHome(t)
+	defer cleanUp(t, testHome)
+	file := filepath.Join(testHome, "foo")
+	assert.NoError(t, ioutil.WriteFile(file, []byte("old"), 0644))
+	assert.NoError(t, writeFileAtomic(file, []byte("new"), 0600))
+	bs, err := ioutil.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(bs))
+	names, err := ioutil.ReadDir(testHome)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(names))
+	assert.Equal(t, os.FileMode(0600), names[0].Mode().Perm())
+}
+
+func TestDownloadedWallpapersChronological(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	makeTestWallpapers(t, a.Config, "140120", "950616", "991231")
+
+	files, err := a.storage.DownloadedWallpapers()
+	assert.NoError(t, err)
+	assert.Equal(t, []Wallpaper{apodOn("950616"), apodOn("991231"), apodOn("140120")}, files)
+}
+
+func TestMigrate(t *testing.T) {
+	a, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	for _, name := range []string{"apod-img-950616", "apod-meta-140121.json", "apod-img-2014-01-20"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(a.Config.WallpaperDir, name), []byte{}, 0644))
+	}
+	assert.NoError(t, a.storage.migrate())
+	files, err := a.storage.files()
+	assert.NoError(t, err)
+	sort.Strings(files)
+	assert.Equal(t, []string{"apod-img-1995-06-16", "apod-img-2014-01-20", "apod-meta-2014-01-21.json"}, files)
 }
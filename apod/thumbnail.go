@@ -0,0 +1,88 @@
+package apod
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+)
+
+const (
+	youtubeThumbnails = "https://img.youtube.com/vi/%s/"
+	vimeoOEmbed       = "https://vimeo.com/api/oembed.json"
+)
+
+var (
+	youtubeID = regexp.MustCompile(`youtube(?:-nocookie)?\.com/(?:embed/|watch\?v=)([\w-]+)`)
+	vimeoID   = regexp.MustCompile(`vimeo\.com/(?:video/)?(\d+)`)
+)
+
+// Thumbnails finds still images for the videos of video days.
+type Thumbnails struct {
+	*Fetcher
+	// YouTube is the address of the thumbnails of a YouTube video, %s is
+	// replaced by the id of the video.
+	YouTube string
+	// Vimeo is the oEmbed endpoint of Vimeo.
+	Vimeo string
+}
+
+// NewThumbnails constructs Thumbnails asking the video sites through f.
+func NewThumbnails(f *Fetcher) *Thumbnails {
+	return &Thumbnails{Fetcher: f, YouTube: youtubeThumbnails, Vimeo: vimeoOEmbed}
+}
+
+// URLs returns the addresses of stills for the video of e, the best first.
+// A video file without a poster falls back to the thumbnail of the page. It
+// returns none for videos it knows no stills for.
+func (t *Thumbnails) URLs(ctx context.Context, e *Entry) ([]string, error) {
+	if e.Poster != "" {
+		return []string{e.Poster}, nil
+	}
+	if m := youtubeID.FindStringSubmatch(e.Video); m != nil {
+		base := fmt.Sprintf(t.YouTube, m[1])
+		return []string{base + "maxresdefault.jpg", base + "hqdefault.jpg"}, nil
+	}
+	if m := vimeoID.FindStringSubmatch(e.Video); m != nil {
+		thumbnail, err := t.vimeo(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		return []string{thumbnail}, nil
+	}
+	if e.Thumbnail != "" {
+		return []string{e.Thumbnail}, nil
+	}
+	return nil, nil
+}
+
+// vimeo asks the oEmbed endpoint of Vimeo for the thumbnail of the video.
+func (t *Thumbnails) vimeo(ctx context.Context, id string) (string, error) {
+	q := url.Values{"url": {"https://vimeo.com/" + id}, "width": {"1920"}}
+	resp, err := t.get(ctx, t.Vimeo+"?"+q.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var oembed map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&oembed); err != nil {
+		return "", fmt.Errorf("Could not read the oEmbed of Vimeo video %s, because: %v", id, err)
+	}
+	thumbnail, _ := oembed["thumbnail_url"].(string)
+	if thumbnail == "" {
+		return "", fmt.Errorf("No thumbnail for Vimeo video %s", id)
+	}
+	return thumbnail, nil
+}
+
+// videoPage returns the page to watch the embedded video on.
+func videoPage(video string) string {
+	if m := youtubeID.FindStringSubmatch(video); m != nil {
+		return "https://www.youtube.com/watch?v=" + m[1]
+	}
+	if m := vimeoID.FindStringSubmatch(video); m != nil {
+		return "https://vimeo.com/" + m[1]
+	}
+	return video
+}
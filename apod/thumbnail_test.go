@@ -0,0 +1,117 @@
+package apod
+
+import (
+	"context"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testThumbnails() *Thumbnails {
+	t := NewThumbnails(NewFetcher())
+	t.YouTube = testAPODSite + "vi/%s/"
+	t.Vimeo = testAPODSite + "vimeo/oembed.json"
+	return t
+}
+
+func TestThumbnailURLs(t *testing.T) {
+	th := testThumbnails()
+	for video, expected := range map[string][]string{
+		"http://www.youtube.com/embed/i3StAXEbGSM?rel=0": {
+			testAPODSite + "vi/i3StAXEbGSM/maxresdefault.jpg",
+			testAPODSite + "vi/i3StAXEbGSM/hqdefault.jpg"},
+		"https://player.vimeo.com/video/76979871":        {testAPODSite + "vimeo/76979871_1920.jpg"},
+		"http://localhost:8765/apod/image/1409/clip.mp4": nil,
+	} {
+		urls, err := th.URLs(context.Background(), &Entry{Video: video})
+		assert.NoError(t, err, video)
+		assert.Equal(t, expected, urls, video)
+	}
+	urls, err := th.URLs(context.Background(), &Entry{Video: "http://localhost:8765/clip.mp4", Poster: "http://localhost:8765/clip.jpg"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost:8765/clip.jpg"}, urls)
+	urls, err = th.URLs(context.Background(), &Entry{Video: "http://localhost:8765/clip.mp4", Thumbnail: "http://localhost:8765/page.jpg"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://localhost:8765/page.jpg"}, urls)
+	th.Vimeo = testAPODSite + "vimeo/missing.json"
+	_, err = th.URLs(context.Background(), &Entry{Video: "https://player.vimeo.com/video/76979871"})
+	assert.Error(t, err)
+}
+
+func TestVideoPage(t *testing.T) {
+	assert.Equal(t, "https://www.youtube.com/watch?v=i3StAXEbGSM", videoPage("http://www.youtube.com/embed/i3StAXEbGSM?rel=0&controls=0"))
+	assert.Equal(t, "https://vimeo.com/76979871", videoPage("https://player.vimeo.com/video/76979871"))
+	assert.Equal(t, "http://localhost:8765/clip.mp4", videoPage("http://localhost:8765/clip.mp4"))
+}
+
+func TestParseEntryPoster(t *testing.T) {
+	base, err := url.Parse(testAPODSite + "apod/")
+	assert.NoError(t, err)
+	e, err := parseEntry(strings.NewReader(`<center><video controls poster="image/2301/clip.jpg">`+
+		`<source src="image/2301/clip.mp4" type="video/mp4"></video></center>`), base)
+	assert.NoError(t, err)
+	assert.Equal(t, testAPODSite+"apod/image/2301/clip.jpg", e.Poster)
+	assert.Equal(t, testAPODSite+"apod/image/2301/clip.mp4", e.Video)
+}
+
+func TestDownloadVideoStill(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	setToday(f, "141013")
+	w := apodOn("141013")
+	loaded, err := f.loader.Download(context.Background(), w)
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	e, err := f.storage.Entry(w)
+	assert.NoError(t, err)
+	assert.True(t, e.VideoStill)
+	assert.Equal(t, "https://www.youtube.com/watch?v=i3StAXEbGSM", f.pageURL(w))
+	assert.Equal(t, testAPODSite+"apod/ap140921.html", f.pageURL(apodOn("140921")))
+}
+
+func TestDownloadVideoStillWithoutPoster(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	setToday(f, "141014")
+	w := apodOn("141014")
+	loaded, err := f.loader.Download(context.Background(), w)
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	e, err := f.storage.Entry(w)
+	assert.NoError(t, err)
+	assert.Equal(t, testAPODSite+"apod/image/1409/m8_chua_2500.jpg", e.Thumbnail)
+	assert.True(t, e.VideoStill)
+}
+
+func TestDownloadVideoStillDoesNotResumeOtherURL(t *testing.T) {
+	f, testHome := frontendForTestConfigured(t)
+	defer cleanUp(t, testHome)
+	writeTestImage(t, filepath.Join(testHome, "still.png"), image.NewGray(image.Rect(0, 0, 16, 9)))
+	still, err := ioutil.ReadFile(filepath.Join(testHome, "still.png"))
+	assert.NoError(t, err)
+	maxres := &flakyServer{content: testImage(t), drops: 1, fails: 3, status: http.StatusServiceUnavailable}
+	hq := &flakyServer{content: still}
+	mux := http.NewServeMux()
+	mux.Handle("/vi/i3StAXEbGSM/maxresdefault.jpg", maxres)
+	mux.Handle("/vi/i3StAXEbGSM/hqdefault.jpg", hq)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	f.APOD.Backoff = time.Millisecond
+	f.loader.Thumbnails.YouTube = server.URL + "/vi/%s/"
+	e := &Entry{Source: apodName, Date: adate("141013"), Video: "http://www.youtube.com/embed/i3StAXEbGSM?rel=0"}
+	loaded, err := f.loader.downloadStill(context.Background(), f.APOD, e)
+	assert.NoError(t, err)
+	assert.True(t, loaded)
+	assert.Equal(t, []string{""}, hq.ranges, "the other url is not resumed on the partial file")
+	bs, err := ioutil.ReadFile(f.Config.fileName(e.Wallpaper()))
+	assert.NoError(t, err)
+	assert.Equal(t, still, bs)
+}
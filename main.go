@@ -1,15 +1,12 @@
 package main
 
 import (
-	"flag"
 	"github.com/slspeek/apod-bg/apod"
 	"os"
 )
 
 func main() {
-	flag.Parse()
-
-	err := apod.Execute()
+	err := apod.Execute(os.Args[1:])
 	if err != nil {
 		os.Exit(1)
 	}